@@ -5,22 +5,33 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"io"
+	"io/fs"
 	"log/slog"
 	"maps"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"git.sr.ht/~rockorager/vaxis"
 	vxspinner "git.sr.ht/~rockorager/vaxis/widgets/spinner"
@@ -44,29 +55,65 @@ func main() {
 	}
 
 	var quitErr error
+	var output []string // printed to stdout once the terminal is restored
+	var exitCode int
 	defer func() {
 		if quitErr != nil {
 			slog.Error("quit due to error", "error", quitErr.Error())
+			exitCode = 1
+		}
+		for _, o := range output {
+			fmt.Println(o)
 		}
 		if buf, ok := slogWriter.(*bytes.Buffer); ok {
 			io.Copy(os.Stderr, buf)
 		}
-		if quitErr != nil {
-			os.Exit(1)
+		if exitCode != 0 {
+			os.Exit(exitCode)
 		}
 	}()
 
-	if len(os.Args) > 1 {
-		switch cmd := os.Args[1]; cmd {
-		case markerHighlight, markerStay, markerLabel:
+	configDir, _ := os.UserConfigDir()
+	flagConfig := flag.String("config", filepath.Join(configDir, "cmenu", "config.toml"), "path to the config file")
+	flagOnly := flag.String("only", "", "comma separated names of the scripts to show, rather than the on-start ones")
+	flagQuery := flag.String("query", "", "initial input")
+	flagPrefix := flag.String("prefix", "", "start in this prefix trigger")
+	flagOutput := flag.Bool("output", false, "print picks to stdout and quit, as if every script set output")
+	flagProfile := flag.String("profile", os.Getenv("CMENU_PROFILE"), "profile from the config to use, defaults to $CMENU_PROFILE")
+	flag.Parse()
+
+	var checkMode bool // only validate the config
+
+	// lines piped in are the menu, dmenu style
+	var stdinMode bool
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		stdinMode = true
+	}
+
+	if flag.NArg() > 0 {
+		switch cmd := flag.Arg(0); cmd {
+		case markerHighlight, markerStay, markerLabel, markerDim, markerItalic, markerStrikethrough:
 			fmt.Print(oscPrefix + cmd + oscTerm)
 			return
+		case markerID, markerFg, markerBg, markerRight, markerIcon:
+			if flag.NArg() != 2 {
+				quitErr = fmt.Errorf("%s needs argument", cmd)
+				return
+			}
+			if cmd == markerFg || cmd == markerBg {
+				if _, err := parseColour(flag.Arg(1)); err != nil {
+					quitErr = err
+					return
+				}
+			}
+			fmt.Print(oscPrefix + cmd + ";" + flag.Arg(1) + oscTerm)
+			return
 		case "image":
-			if len(os.Args) != 3 {
+			if flag.NArg() != 2 {
 				quitErr = fmt.Errorf("image needs argument")
 				return
 			}
-			switch file := os.Args[2]; file {
+			switch file := flag.Arg(1); file {
 			case "-":
 				fmt.Print(oscPrefix + markerImageData + ";")
 				enc := base64.NewEncoder(base64.StdEncoding, os.Stdout)
@@ -78,62 +125,161 @@ func main() {
 				fmt.Print(oscPrefix + markerImagePath + ";" + file + oscTerm)
 				return
 			}
+		case "-", "stdin":
+			stdinMode = true
+		case "check":
+			checkMode = true
 		default:
 			quitErr = fmt.Errorf("unknown command %q", cmd)
 			return
 		}
 	}
 
-	configDir, _ := os.UserConfigDir()
-	confPath := filepath.Join(configDir, "cmenu", "config.toml")
-
-	conf, err := parseConfig(confPath)
-	if err != nil {
+	conf, err := parseConfig(*flagConfig)
+	switch {
+	case stdinMode && !checkMode && errors.Is(err, fs.ErrNotExist):
+	case err != nil:
 		quitErr = err
 		return
 	}
-
-	var scripts = map[string]*script{}
-	var scriptOrder = make([]string, 0, len(conf.Scripts))
-	for _, sconf := range conf.Scripts {
-		scripts[sconf.Name] = &script{scriptConf: sconf}
-		scriptOrder = append(scriptOrder, sconf.Name)
+	if checkMode {
+		problems := validateConfig(conf)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			exitCode = 1
+		}
+		return
+	}
+	if stdinMode {
+		// only the config's keys apply, the menu is a single script of what was piped in
+		conf.Scripts = []scriptConf{{Name: stdinScriptName, Triggers: []string{"on-start"}, Output: true}}
 	}
-
 	var (
-		triggersOnStart  = map[ /* script name */ string]struct{}{}
-		triggersPrefix   = map[ /* prefix */ string] /* script names */ []string{}
-		triggersScript   = map[ /* script */ string] /* script names */ []string{}
-		triggersInterval = map[ /* script name */ string]time.Duration{}
+		scripts     map[string]*script
+		scriptOrder []string
+
+		triggersOnStart  map[ /* script name */ string]struct{}
+		triggersPrefix   map[ /* prefix */ string] /* script names */ []string
+		triggersScript   map[ /* script */ string] /* script names */ []string
+		triggersInterval map[ /* script name */ string]time.Duration
+
+		only []string // --only shows just these scripts, at start and behind prefixes
+		keys map[string]string
 	)
-	for _, sconf := range conf.Scripts {
-		for _, trigger := range sconf.Triggers {
-			switch typ, value, _ := strings.Cut(trigger, " "); typ {
-			case "on-start":
-				triggersOnStart[sconf.Name] = struct{}{}
-			case "pre":
-				triggersPrefix[value] = append(triggersPrefix[value], sconf.Name)
-			case "script":
-				triggersScript[value] = append(triggersScript[value], sconf.Name)
-			case "interval":
-				triggersInterval[sconf.Name], err = time.ParseDuration(value)
-				if err != nil {
-					quitErr = fmt.Errorf("parse %q: parse duration: %w", sconf.Name, err)
-					return
+
+	// applyConfig derives the scripts, triggers and keys from newConf. it runs again when the config
+	// changes, so scripts whose config is the same are kept along with their lines. the maps are
+	// replaced rather than changed, goroutines can hold on to the ones they started with
+	applyConfig := func(newConf config) error {
+		if *flagOutput {
+			newConf.Output = true
+		}
+
+		// problems confined to a script are shown in its group, so one bad script doesn't stop the menu
+		scriptErrs := map[string]error{}
+		if !stdinMode {
+			var problems []error
+			for _, problem := range validateConfig(newConf) {
+				if serr := (*scriptConfigError)(nil); errors.As(problem, &serr) {
+					scriptErrs[serr.script] = errors.Join(scriptErrs[serr.script], problem)
+				} else {
+					problems = append(problems, problem)
 				}
-			default:
-				quitErr = fmt.Errorf("parse %q: unknown trigger type %q", sconf.Name, typ)
-				return
 			}
+			if len(problems) > 0 {
+				return errors.Join(problems...)
+			}
+		}
+
+		newScripts := map[string]*script{}
+		newOrder := make([]string, 0, len(newConf.Scripts))
+		for _, sconf := range newConf.Scripts {
+			sc := scripts[sconf.Name]
+			confErr := scriptErrs[sconf.Name]
+			if sc == nil || !reflect.DeepEqual(sc.scriptConf, sconf) || fmt.Sprint(sc.confErr) != fmt.Sprint(confErr) {
+				sc = &script{scriptConf: sconf, stdin: stdinMode, confErr: confErr}
+			}
+			newScripts[sconf.Name] = sc
+			newOrder = append(newOrder, sconf.Name)
+		}
+
+		var (
+			onStart  = map[string]struct{}{}
+			prefix   = map[string][]string{}
+			script   = map[string][]string{}
+			interval = map[string]time.Duration{}
+		)
+		for _, sconf := range newConf.Scripts {
+			for _, trigger := range sconf.Triggers {
+				switch typ, value, _ := strings.Cut(trigger, " "); typ {
+				case "on-start":
+					onStart[sconf.Name] = struct{}{}
+				case "pre":
+					prefix[value] = append(prefix[value], sconf.Name)
+				case "script":
+					script[value] = append(script[value], sconf.Name)
+				case "interval":
+					var err error
+					interval[sconf.Name], err = time.ParseDuration(value)
+					if err != nil {
+						return fmt.Errorf("parse %q: parse duration: %w", sconf.Name, err)
+					}
+				default:
+					return fmt.Errorf("parse %q: unknown trigger type %q", sconf.Name, typ)
+				}
+			}
+		}
+
+		if *flagProfile != "" && !stdinMode {
+			prof, ok := newConf.Profiles[*flagProfile]
+			if !ok {
+				return fmt.Errorf("profile: unknown profile %q", *flagProfile)
+			}
+			if err := applyProfile(&newConf, prof, onStart, prefix); err != nil {
+				return fmt.Errorf("profile %q: %w", *flagProfile, err)
+			}
+		}
+
+		var newOnly []string
+		if *flagOnly != "" && !stdinMode {
+			newOnly = strings.Split(*flagOnly, ",")
+			clear(onStart)
+			for _, scriptName := range newOnly {
+				if _, ok := newScripts[scriptName]; !ok {
+					return fmt.Errorf("only: unknown script %q", scriptName)
+				}
+				onStart[scriptName] = struct{}{}
+			}
+		}
+
+		newKeys, err := parseKeys(newConf.Keys)
+		if err != nil {
+			return fmt.Errorf("parse keys: %w", err)
 		}
+
+		slog.Info("loaded triggers",
+			"on_start", slices.Collect(maps.Keys(onStart)),
+			"prefix", prefix,
+			"script", script,
+			"interval", interval,
+		)
+
+		conf, scripts, scriptOrder = newConf, newScripts, newOrder
+		triggersOnStart, triggersPrefix, triggersScript, triggersInterval = onStart, prefix, script, interval
+		only, keys = newOnly, newKeys
+		return nil
+	}
+	if err := applyConfig(conf); err != nil {
+		quitErr = err
+		return
 	}
 
-	slog.Info("loaded triggers",
-		"on_start", slices.Collect(maps.Keys(triggersOnStart)),
-		"prefix", triggersPrefix,
-		"script", triggersScript,
-		"interval", triggersInterval,
-	)
+	if _, ok := triggersPrefix[*flagPrefix]; *flagPrefix != "" && !ok {
+		quitErr = fmt.Errorf("prefix: no scripts for prefix %q", *flagPrefix)
+		return
+	}
 
 	vx, err := vaxis.New(vaxis.Options{})
 	if err != nil {
@@ -156,46 +302,100 @@ func main() {
 		go func() {
 			defer spinner.stop()
 
-			if err := loadScript(ctx, vx, nil, sconf, ""); err != nil {
-				vx.PostEvent(quitErrorf("load script %q: %w", sconf.Name, err))
+			load := loadScript
+			if sconf.stdin {
+				load = loadStdin
+			}
+			if err := load(ctx, vx, nil, sconf, ""); err != nil {
+				vx.PostEvent(eventScriptError{sconf, err})
 				return
 			}
 		}()
 	}
 
+	if !stdinMode {
+		go watchConfig(ctx, vx, *flagConfig)
+	}
+
+	// when picks are printed, quitting without one exits 1 like dmenu, so a caller can tell it was cancelled
+	cancelledExitCode := func() int {
+		if conf.Output || slices.ContainsFunc(conf.Scripts, func(sc scriptConf) bool { return sc.Output }) {
+			return 1
+		}
+		return 0
+	}
+
+	type interval struct {
+		sc     *script
+		every  time.Duration
+		cancel context.CancelFunc
+	}
+	var intervals = map[ /* script name */ string]interval{}
+
 	// each interval-triggered script reloads itself on its own ticker, so
-	// the event loop doesn't need to drive periodic reloads
-	for scriptName, inter := range triggersInterval {
-		sc := scripts[scriptName]
-		go func() {
-			ticker := time.NewTicker(inter)
-			defer ticker.Stop()
+	// the event loop doesn't need to drive periodic reloads. syncIntervals
+	// starts tickers for new scripts and stops them for removed or changed ones
+	syncIntervals := func() {
+		for scriptName, iv := range intervals {
+			if every, ok := triggersInterval[scriptName]; !ok || every != iv.every || scripts[scriptName] != iv.sc {
+				iv.cancel()
+				delete(intervals, scriptName)
+			}
+		}
+		for scriptName, every := range triggersInterval {
+			if _, ok := intervals[scriptName]; ok {
+				continue
+			}
+			sc := scripts[scriptName]
+			ctx, cancel := context.WithCancel(ctx)
+			intervals[scriptName] = interval{sc, every, cancel}
+			go func() {
+				ticker := time.NewTicker(every)
+				defer ticker.Stop()
 
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					sc.mu.Lock()
-					loaded := !sc.lastLoaded.IsZero()
-					query := sc.lastQuery
-					sc.mu.Unlock()
-					if !loaded {
-						continue
-					}
-					if err := loadScript(ctx, vx, nil, sc, query); err != nil {
-						vx.PostEvent(eventQuitError(err))
+				for {
+					select {
+					case <-ctx.Done():
 						return
+					case <-ticker.C:
+						sc.mu.Lock()
+						loaded := !sc.lastLoaded.IsZero()
+						query := sc.lastQuery
+						sc.mu.Unlock()
+						if !loaded {
+							continue
+						}
+						if err := loadScript(ctx, vx, nil, sc, query); err != nil {
+							vx.PostEvent(eventScriptError{sc, err})
+						}
 					}
 				}
-			}
-		}()
+			}()
+		}
+	}
+	syncIntervals()
+
+	// without a readable history file, picks are only remembered for this run
+	hist := &history{items: map[historyKey]*historyItem{}}
+	if path, err := historyPath(); err != nil {
+		slog.Error("find history", "error", err)
+	} else if h, err := loadHistory(path); err != nil {
+		slog.Error("load history", "error", err)
+	} else {
+		hist = h
 	}
 
+	var configErr error // from the last reload, which was then ignored
+
 	input := textinput.
 		New().
 		SetPrompt("> ")
 	input.Prompt = vaxis.Style{Foreground: vaxis.ColorBlack}
+	if *flagPrefix != "" {
+		input.SetContent(*flagPrefix + " " + *flagQuery)
+	} else {
+		input.SetContent(*flagQuery)
+	}
 
 	const scriptQueryDebounce = 150 * time.Millisecond
 	var lastScriptQuery string
@@ -212,18 +412,34 @@ func main() {
 	var imgState imageState
 
 	var index int
+	var offset int // first list line in view
+	var showPreview = true
+	var showLog bool
 	var selectedScripts []string
 
+	type cursorKey struct {
+		script, id string
+		nth        int
+	}
+
 	type line struct {
-		script, text string
-		style        lineStyle
+		script string
+		listItem
+		score    int
+		frecency float64
+		matches  []int
 	}
 
 	var visScripts []string
 	var visLines []line
+	var iconScripts = map[string]bool{} // scripts with a line that has an icon, so all their lines make room
+
+	// lines toggled for multi-select, kept across filter changes
+	type mark struct{ script, id string }
+	var marked = map[mark]struct{}{}
 
 	active := func() (*script, line, bool) {
-		if index < 0 || index >= len(visLines) || visLines[index].style.label {
+		if index < 0 || index >= len(visLines) || visLines[index].style.label || visLines[index].style.error {
 			return nil, line{}, false
 		}
 		item := visLines[index]
@@ -240,6 +456,139 @@ func main() {
 		return from
 	}
 
+	// seek returns the nearest non-label line to `to`, looking in direction `dir` first
+	seek := func(lines []line, to, dir int) int {
+		to = clamp(to, 0, len(lines)-1)
+		if to < 0 || !lines[to].style.label {
+			return to
+		}
+		if n := step(lines, to, dir); n != to {
+			return n
+		}
+		return step(lines, to, -dir)
+	}
+
+	type run struct {
+		sc    *script
+		items []listItem
+	}
+
+	// collectRuns gathers the marked lines grouped by script, or the active line if none are marked.
+	// if only is set, just that script's lines are taken. stay reports if any line or script wants to stay open
+	collectRuns := func(only string) (runs []run, stay bool) {
+		if len(marked) == 0 {
+			sc, ln, ok := active()
+			if !ok || (only != "" && sc.Name != only) {
+				return nil, false
+			}
+			return []run{{sc, []listItem{ln.listItem}}}, ln.style.stay || sc.StayOpen
+		}
+		for _, scriptName := range selectedScripts {
+			if only != "" && scriptName != only {
+				continue
+			}
+			sc := scripts[scriptName]
+			r := run{sc: sc}
+			for _, it := range sc.items {
+				k := mark{scriptName, it.id}
+				if _, ok := marked[k]; ok {
+					r.items = append(r.items, it)
+					stay = stay || it.style.stay || sc.StayOpen
+					delete(marked, k)
+				}
+			}
+			if len(r.items) > 0 {
+				runs = append(runs, r)
+			}
+		}
+		return runs, stay
+	}
+
+	// runScripts runs each script with its items in run mode, or action mode if action is set. then
+	// quits, or reloads the scripts and the ones they trigger. if a script outputs, cmenu quits printing
+	// what the script wrote to stdout, or its items if it wrote nothing
+	runScripts := func(runs []run, action, query string, stay bool) {
+		if len(runs) == 0 {
+			return
+		}
+		// the config may reload while this runs
+		scripts, triggersScript, outputAll := scripts, triggersScript, conf.Output
+		go func() {
+			var out eventOutput
+			var outputting bool
+			for _, r := range runs {
+				args := make([]string, 0, len(r.items))
+				for _, it := range r.items {
+					args = append(args, it.arg)
+				}
+				var stdout []byte
+				if !r.sc.stdin {
+					var err error
+					stdout, err = execScript(ctx, vx, spinner, r.sc, query, action, args, outputAll || r.sc.Output)
+					if err != nil {
+						vx.PostEvent(eventScriptError{r.sc, err})
+						return
+					}
+				}
+				if action == "" && r.sc.Sort == sortFrecency {
+					ids := make([]string, 0, len(r.items))
+					for _, it := range r.items {
+						ids = append(ids, it.id)
+					}
+					if err := hist.record(r.sc.Name, ids); err != nil {
+						slog.Error("record history", "script", r.sc.Name, "error", err)
+					}
+				}
+				if !outputAll && !r.sc.Output {
+					continue
+				}
+				outputting = true
+				if text := strings.TrimRight(string(stdout), "\n"); text != "" {
+					out = append(out, strings.Split(text, "\n")...)
+				} else {
+					out = append(out, args...)
+				}
+			}
+			if outputting {
+				vx.PostEvent(out)
+				return
+			}
+			if !stay {
+				vx.PostEvent(eventOutput(nil)) // a pick with nothing to print, rather than a cancel
+				return
+			}
+			for _, r := range runs {
+				if err := loadScript(ctx, vx, spinner, r.sc, query); err != nil {
+					vx.PostEvent(eventScriptError{r.sc, err})
+				}
+				for _, scriptName := range triggersScript[r.sc.Name] {
+					if err := loadScript(ctx, vx, spinner, scripts[scriptName], query); err != nil {
+						vx.PostEvent(eventScriptError{scripts[scriptName], err})
+					}
+				}
+			}
+		}()
+	}
+
+	// rank orders lines by match score, then frecency, best first. labels stay put and only the lines
+	// between them are sorted, so sections stay under their headings
+	rank := func(lines []line) {
+		for len(lines) > 0 {
+			end := 1
+			for end < len(lines) && !lines[end].style.label {
+				end++
+			}
+			sec := lines[:end]
+			if sec[0].style.label {
+				sec = sec[1:]
+			}
+			slices.SortStableFunc(sec, func(a, b line) int {
+				return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(b.frecency, a.frecency))
+			})
+			lines = lines[end:]
+		}
+	}
+
 	stepGroup := func(lines []line, from, dir int) int {
 		cur := from
 		for cur >= 0 && cur < len(lines) && lines[cur].script == lines[from].script {
@@ -259,72 +608,130 @@ func main() {
 		win.Clear()
 
 		width, height := win.Size()
-
-		input.Update(ev)
+		listH := max(height-2, 1)
+
+		// bound keys are ours, anything else goes to the input. the active script's own actions win over global ones
+		var action string
+		var scAction *scriptAction
+		if key, ok := ev.(vaxis.Key); ok {
+			name, _ := normaliseKey(key.String())
+			action = keys[name]
+			if sc, _, ok := active(); ok {
+				if i := slices.IndexFunc(sc.Actions, func(a scriptAction) bool {
+					actName, err := normaliseKey(a.Key)
+					return err == nil && actName == name
+				}); i >= 0 {
+					scAction = &sc.Actions[i]
+				}
+			}
+		}
+		if action == "" && scAction == nil {
+			input.Update(ev)
+		}
 		scriptQuery, filterQuery := parseInput(input.String())
 
 		switch ev := ev.(type) {
 		case vaxis.Key:
-			switch ev.String() {
-			case "Escape", "Ctrl+c":
+			if scAction != nil {
+				sc, _, _ := active()
+				runs, stay := collectRuns(sc.Name)
+				runScripts(runs, scAction.Name, scriptQuery, stay || scAction.StayOpen)
+				break
+			}
+			switch action {
+			case actionQuit:
+				exitCode = cancelledExitCode()
 				return
-			case "Down":
+			case actionDown:
 				index = step(visLines, index, +1)
-			case "Up":
+			case actionUp:
 				index = step(visLines, index, -1)
-			case "Shift+Down":
+			case actionGroupNext:
 				index = stepGroup(visLines, index, +1)
-			case "Shift+Up":
+			case actionGroupPrev:
 				index = stepGroup(visLines, index, -1)
-			case "End":
-			case "Home":
-			case "Page_Down":
-			case "Page_Up":
-			case "Ctrl+r":
-				sconf, _, ok := active()
-				if !ok {
-					break
+			case actionMark, actionMarkDown, actionMarkUp:
+				if _, ln, ok := active(); ok {
+					k := mark{ln.script, ln.id}
+					if _, ok := marked[k]; ok {
+						delete(marked, k)
+					} else {
+						marked[k] = struct{}{}
+					}
 				}
-				sq := scriptQuery
-				go func() {
-					if err := loadScript(ctx, vx, spinner, sconf, sq); err != nil {
-						vx.PostEvent(quitErrorf("load script %q: %w", sconf.Name, err))
-						return
+				switch action {
+				case actionMarkDown:
+					index = step(visLines, index, +1)
+				case actionMarkUp:
+					index = step(visLines, index, -1)
+				}
+			case actionLast:
+				index = seek(visLines, len(visLines)-1, -1)
+			case actionFirst:
+				index = seek(visLines, 0, +1)
+				offset = 0
+			case actionPageDown:
+				index = seek(visLines, index+listH, +1)
+				offset += listH
+			case actionPageUp:
+				index = seek(visLines, index-listH, -1)
+				offset -= listH
+			case actionTogglePreview:
+				showPreview = !showPreview
+			case actionToggleLog:
+				showLog = !showLog
+			case actionForget:
+				if sc, ln, ok := active(); ok {
+					if _, err := hist.forget(sc.Name, ln.id); err != nil {
+						slog.Error("forget history", "script", sc.Name, "error", err)
 					}
-				}()
-			case "Enter", "Shift+Enter":
-				sconf, ln, ok := active()
-				if !ok {
+				}
+			case actionReload:
+				// the script under the cursor, which may be one of its error lines
+				if index < 0 || index >= len(visLines) {
+					break
+				}
+				sconf := scripts[visLines[index].script]
+				if sconf.stdin {
 					break
 				}
-				stay := ln.style.stay || sconf.StayOpen || ev.Modifiers&vaxis.ModShift != 0
 				sq := scriptQuery
 				go func() {
-					if err := execScript(ctx, spinner, sconf, sq, ln.text); err != nil {
-						vx.PostEvent(quitErrorf("run script item for %q: %w", sconf.Name, err))
-						return
-					}
-					if !stay {
-						vx.PostEvent(vaxis.QuitEvent{})
-						return
-					}
 					if err := loadScript(ctx, vx, spinner, sconf, sq); err != nil {
-						vx.PostEvent(quitErrorf("load script %q: %w", sconf.Name, err))
-						return
-					}
-					for _, scriptName := range triggersScript[sconf.Name] {
-						if err := loadScript(ctx, vx, spinner, scripts[scriptName], sq); err != nil {
-							vx.PostEvent(quitErrorf("load script %q: %w", sconf.Name, err))
-							return
-						}
+						vx.PostEvent(eventScriptError{sconf, err})
 					}
 				}()
+			case actionRun, actionRunStay:
+				runs, stay := collectRuns("")
+				if stdinMode && len(runs) == 0 && input.String() != "" {
+					// print what was typed if nothing matches, like dmenu
+					output = []string{input.String()}
+					return
+				}
+				runScripts(runs, "", scriptQuery, stay || action == actionRunStay)
 			}
 		case vaxis.QuitEvent:
+			exitCode = cancelledExitCode()
 			return
-		case eventQuitError:
-			quitErr = ev
+		case eventConfig:
+			configErr = ev.err
+			if configErr == nil {
+				configErr = applyConfig(ev.conf)
+			}
+			if configErr == nil {
+				syncIntervals()
+			}
+			if configErr != nil {
+				slog.Error("reload config", "error", configErr)
+			}
+		case eventOutput:
+			output = ev
 			return
+		case eventScriptError:
+			slog.Error("script failed", "script", ev.sc.Name, "error", ev.err)
+			ev.sc.mu.Lock()
+			ev.sc.err = ev.err
+			ev.sc.mu.Unlock()
 		case vaxis.SyncFunc:
 			ev()
 		case vaxis.Redraw:
@@ -354,6 +761,11 @@ func main() {
 			for _, scriptName := range selectedScripts {
 				selectedScripts = append(selectedScripts, triggersScript[scriptName]...)
 			}
+			if len(only) > 0 {
+				selectedScripts = slices.DeleteFunc(selectedScripts, func(scriptName string) bool {
+					return !slices.Contains(only, scriptName)
+				})
+			}
 		}
 
 		// fallback to start scripts
@@ -368,63 +780,121 @@ func main() {
 		// invoke scripts that haven't been run yet, or reload after script query changes
 		for _, scriptName := range selectedScripts {
 			script := scripts[scriptName]
-			if !script.lastLoaded.IsZero() && !reloadScripts {
+			if script.stdin {
+				continue
+			}
+			if (!script.lastLoaded.IsZero() || script.err != nil) && !reloadScripts {
 				continue
 			}
 			sq := scriptQuery
 			go func() {
 				if err := loadScript(ctx, vx, spinner, script, sq); err != nil {
-					vx.PostEvent(eventQuitError(err))
-					return
+					vx.PostEvent(eventScriptError{script, err})
 				}
 			}()
 		}
 
+		// the item under the cursor, which stays there when lines arrive, reload, or are filtered and ranked.
+		// nth tells apart items with the same id
+		var keep *cursorKey
+		if index >= 0 && index < len(visLines) {
+			ln := visLines[index]
+			keep = &cursorKey{script: ln.script, id: ln.id}
+			for _, l := range visLines[:index] {
+				if l.script == ln.script && l.id == ln.id {
+					keep.nth++
+				}
+			}
+		}
+
 		visLines = visLines[:0]
 		visScripts = visScripts[:0]
+		clear(iconScripts)
+
+		filter := parseQuery(filterQuery)
 
 		for _, scriptName := range selectedScripts {
 			script := scripts[scriptName]
 
-			var scriptVisible bool
-			for _, item := range script.lines {
-				text, style := parseLineStyle(item)
-				if filterQuery == "" || match(text, filterQuery) {
-					visLines = append(visLines, line{script: scriptName, text: text, style: style})
-					scriptVisible = true
+			// match against the displayed columns unless the script says otherwise, so hidden ids and paths don't match
+			filterColumns := script.FilterColumns
+			if len(filterColumns) == 0 {
+				filterColumns = script.Columns
+			}
+
+			var frecencies map[string]float64
+			if script.Sort == sortFrecency {
+				frecencies = hist.scores(scriptName)
+			}
+
+			start := len(visLines)
+			for _, it := range script.items {
+				if it.style.icon != "" {
+					iconScripts[scriptName] = true
+				}
+				score, matches, ok := filter.match(it.text, filterColumns)
+				if !ok {
+					continue
+				}
+				visLines = append(visLines, line{script: scriptName, listItem: it, score: score, frecency: frecencies[it.id], matches: matches})
+			}
+			if len(filter) > 0 || len(frecencies) > 0 {
+				rank(visLines[start:])
+			}
+			if script.err != nil {
+				for _, text := range errorLines(script.err) {
+					visLines = append(visLines, line{script: scriptName, listItem: listItem{text: text, style: lineStyle{error: true}, id: text}})
 				}
 			}
-			if scriptVisible {
+			if len(visLines) > start {
 				visScripts = append(visScripts, scriptName)
 			}
 		}
 
-		// keep cursor off labels
-		index = clamp(index, 0, len(visLines)-1)
-		if index >= 0 && visLines[index].style.label {
-			if n := step(visLines, index, +1); n != index {
-				index = n
-			} else {
-				index = step(visLines, index, -1)
+		if keep != nil {
+			nth := keep.nth
+			if i := slices.IndexFunc(visLines, func(l line) bool {
+				if l.script != keep.script || l.id != keep.id {
+					return false
+				}
+				nth--
+				return nth < 0
+			}); i >= 0 {
+				index = i
 			}
 		}
 
+		// keep cursor off labels, and in view
+		index = seek(visLines, index, +1)
+		offset = clamp(offset, index-listH+1, index)
+		offset = clamp(offset, 0, max(len(visLines)-listH, 0))
+
+		// the log pane shows the script under the cursor, in place of the preview
+		var logSc *script
+		if showLog && index >= 0 && index < len(visLines) {
+			logSc = scripts[visLines[index].script]
+		}
+
 		var previewSc *script
 		var previewLine string
-		if sc, ln, ok := active(); ok && sc.Preview {
+		var inlinePreview *preview // the item's own, so the script isn't run
+		if sc, ln, ok := active(); ok && (sc.Preview || ln.preview != nil) && showPreview && logSc == nil {
 			previewSc = sc
-			previewLine = ln.text
+			previewLine = ln.arg
+			if ln.preview != nil {
+				inlinePreview = ln.preview.get()
+			}
 		}
 
 		listW := width
 		var prevWin vaxis.Window
-		if previewSc != nil {
+		if previewSc != nil || logSc != nil {
 			listW = width / 2
 			prevWin = win.New(listW+1, 1, width-listW-1, height-2)
 		}
 
 		var key previewKey
-		if previewSc != nil {
+		if previewSc != nil && inlinePreview == nil {
 			key = previewKey{previewSc, previewLine, previewSc.lastLoaded}
 		}
 		if key != lastPreviewKey {
@@ -435,12 +905,12 @@ func main() {
 			if previewTimer != nil {
 				previewTimer.Stop()
 			}
-			if previewSc != nil {
+			if key.sc != nil {
 				sc, line, sq := previewSc, previewLine, scriptQuery
 				cols, rows := prevWin.Size()
 				previewTimer = time.AfterFunc(previewDebounce, func() {
 					if err := previewScript(ctx, vx, previewSpinner, sc, sq, line, cols, rows); err != nil {
-						vx.PostEvent(quitErrorf("preview script %q: %w", sc.Name, err))
+						slog.Error("preview failed", "script", sc.Name, "error", err)
 					}
 				})
 			}
@@ -453,14 +923,28 @@ func main() {
 		spinner.draw(spinWin)
 
 		listWin := win.New(0, 1, listW, height-2)
-		for i, it := range visLines {
-			drawLine(listWin, i, scripts[it.script], it.text, it.style, i == index && !it.style.label)
+		if len(visLines) > listH {
+			drawScrollbar(win.New(listW-1, 1, 1, height-2), offset, len(visLines))
+			listWin = win.New(0, 1, listW-1, height-2)
+		}
+		for i := offset; i < min(offset+listH, len(visLines)); i++ {
+			it := visLines[i]
+			_, isMarked := marked[mark{it.script, it.id}]
+			drawLine(listWin, i-offset, scripts[it.script], it.listItem, it.matches, isMarked, i == index && !it.style.label, iconScripts[it.script])
 		}
 
-		if previewSc != nil {
+		if previewSc != nil || logSc != nil {
 			div := win.New(listW, 1, 1, height-2)
 			div.Fill(vaxis.Cell{Character: vaxis.Character{Grapheme: "│", Width: 1}, Style: vaxis.Style{Foreground: vaxis.ColorBlack}})
+		}
 
+		switch {
+		case logSc != nil:
+			imgState.destroy()
+			drawLog(prevWin, logSc)
+		case inlinePreview != nil:
+			imgState.draw(prevWin, vx, inlinePreview)
+		case previewSc != nil:
 			previewSc.mu.Lock()
 			pv := previewSc.previewResult
 			ready := previewSc.previewLine == previewLine
@@ -472,82 +956,259 @@ func main() {
 				imgState.destroy()
 				previewSpinner.draw(prevWin.New(0, 0, 1, 1))
 			}
-		} else {
+		default:
 			imgState.destroy()
 		}
 
+		var footActions []scriptAction
+		if sc, _, ok := active(); ok {
+			footActions = sc.Actions
+		}
 		footerWin := win.New(0, height-1, width, 1)
-		drawFooter(footerWin, conf, visScripts)
+		if configErr != nil {
+			footerWin.Println(0, vaxis.Segment{
+				Text:  "✗ reload config: " + strings.ReplaceAll(configErr.Error(), "\n", "; "),
+				Style: vaxis.Style{Foreground: vaxis.IndexColor(1)},
+			})
+		} else {
+			drawFooter(footerWin, conf, visScripts, footActions)
+		}
 
 		vx.Render()
 	}
 }
 
-type eventQuitError error
+// eventConfig carries the config after one of its files changed, or why it couldn't be read
+type eventConfig struct {
+	conf config
+	err  error
+}
+
+// eventOutput quits, printing its lines to stdout
+type eventOutput []string
+
+// eventLogged redraws, for the log pane, after a script's log gets an entry
+type eventLogged struct{}
 
-func quitErrorf(f string, a ...any) error {
-	return eventQuitError(fmt.Errorf(f, a...))
+// eventScriptError reports a failed script invocation, shown in the script's group rather than quitting
+type eventScriptError struct {
+	sc  *script
+	err error
 }
 
-func drawLine(win vaxis.Window, i int, script *script, text string, ls lineStyle, selected bool) {
-	if len(script.Columns) > 0 {
-		columns := strings.Split(text, "\t")
-		filtered := make([]string, 0, len(columns))
-		for _, c := range script.Columns { // 1 indexed display columns
-			if i := c - 1; i <= len(columns)-1 {
-				filtered = append(filtered, columns[i])
-			}
-		}
-		text = strings.Join(filtered, " ")
-	} else {
-		text = strings.ReplaceAll(text, "\t", " ")
+// drawLine draws a list line. icons makes room for the icon column, so the script's lines with and without
+// icons line up
+func drawLine(win vaxis.Window, i int, script *script, it listItem, matches []int, marked, selected, icons bool) {
+	ls := it.style
+	columns := script.Columns
+	if ls.error {
+		columns = nil
 	}
+	disp, src := displayRunes(it.text, columns)
 
 	var col string = "▌"
 	if ls.highlight {
 		col = "█"
 	}
+	var gutter string = " "
+	if marked {
+		gutter = "•"
+	}
 
-	var style vaxis.Style
+	style := vaxis.Style{Foreground: ls.fg, Background: ls.bg}
 	if selected {
 		style.Attribute |= vaxis.AttrReverse
 	}
 	if ls.highlight {
 		style.Attribute |= vaxis.AttrBold
 	}
-	if ls.label {
+	if ls.label || ls.dim {
+		style.Attribute |= vaxis.AttrDim
+	}
+	if ls.italic {
+		style.Attribute |= vaxis.AttrItalic
+	}
+	if ls.strikethrough {
+		style.Attribute |= vaxis.AttrStrikethrough
+	}
+	if ls.error {
+		style.Foreground = vaxis.IndexColor(1)
+	}
+	if script.stale {
 		style.Attribute |= vaxis.AttrDim
 	}
+	matchStyle := style
+	matchStyle.Foreground = vaxis.IndexColor(uint8(script.Colour))
+	matchStyle.Attribute |= vaxis.AttrBold
+
+	// the annotation takes the end of the row if it fits in half of it, and the text is cut short of it
+	if ls.right != "" {
+		w, h := win.Size()
+		if rw := win.Vx.RenderedWidth(ls.right); rw < w/2 {
+			rightStyle := style
+			rightStyle.Attribute |= vaxis.AttrDim
+			win.New(w-rw, i, rw, 1).Println(0, vaxis.Segment{Text: ls.right, Style: rightStyle})
+			win = win.New(0, 0, w-rw-1, h)
+		}
+	}
 
-	win.Println(i,
+	segs := make([]vaxis.Segment, 0, 4+len(matches)*2)
+	segs = append(segs,
 		vaxis.Segment{Text: padRight(script.Name, " ", 13)},
 		vaxis.Segment{Text: col, Style: vaxis.Style{Foreground: vaxis.IndexColor(uint8(script.Colour))}},
-		vaxis.Segment{Text: " "},
-		vaxis.Segment{Text: text, Style: style},
+		vaxis.Segment{Text: gutter, Style: vaxis.Style{Foreground: vaxis.IndexColor(uint8(script.Colour))}},
 	)
+	if icons {
+		segs = append(segs, vaxis.Segment{Text: iconCell(win.Vx, ls.icon) + " ", Style: style})
+	}
+	if len(matches) == 0 && it.sgr == nil {
+		segs = append(segs, vaxis.Segment{Text: string(disp), Style: style})
+		win.Println(i, segs...)
+		return
+	}
+
+	// each rune gets the line's style, or matchStyle if it matched, with its own from SGR layered on.
+	// its colours win except over a match's, and its reverse flips the selection's rather than adding to it
+	styles := make([]vaxis.Style, len(disp))
+	for j, s := range src {
+		_, matched := slices.BinarySearch(matches, s)
+		st := style
+		if matched {
+			st = matchStyle
+		}
+		if s >= 0 && it.sgr != nil {
+			own := it.sgr[s]
+			if own.Foreground != 0 && !matched {
+				st.Foreground = own.Foreground
+			}
+			if own.Background != 0 {
+				st.Background = own.Background
+			}
+			if own.UnderlineStyle != vaxis.UnderlineOff {
+				st.UnderlineStyle = own.UnderlineStyle
+			}
+			st.Hyperlink, st.HyperlinkParams = own.Hyperlink, own.HyperlinkParams
+			st.Attribute |= own.Attribute &^ vaxis.AttrReverse
+			st.Attribute ^= own.Attribute & vaxis.AttrReverse
+		}
+		styles[j] = st
+	}
+	for start := 0; start < len(disp); {
+		end := start + 1
+		for end < len(disp) && styles[end] == styles[start] {
+			end++
+		}
+		segs = append(segs, vaxis.Segment{Text: string(disp[start:end]), Style: styles[start]})
+		start = end
+	}
+	win.Println(i, segs...)
 }
 
-type preview struct {
-	text string
-	img  image.Image
+// how many cells the icon column takes, room for an emoji or a nerd font glyph that draws wide
+const iconWidth = 2
+
+// iconCell fits an icon to the icon column. only its first grapheme is kept, padded to iconWidth. if that's
+// wider, like a zwj emoji on a terminal that draws its parts, its first rune is tried, then it's left blank
+func iconCell(vx *vaxis.Vaxis, icon string) string {
+	chars := vaxis.Characters(icon)
+	if len(chars) == 0 {
+		return strings.Repeat(" ", iconWidth)
+	}
+	g := chars[0].Grapheme
+	w := vx.RenderedWidth(g)
+	if w > iconWidth {
+		r, _ := utf8.DecodeRuneInString(g)
+		g = string(r)
+		w = vx.RenderedWidth(g)
+	}
+	if w > iconWidth {
+		g, w = "", 0
+	}
+	return g + strings.Repeat(" ", iconWidth-w)
 }
 
-func parsePreview(out []byte) (*preview, error) {
-	kind, payload, _, ok := cutOSC(string(out))
-	if !ok {
-		return &preview{text: string(out)}, nil
+// displayRunes lays text out the way it's shown in the list: the configured 1 indexed columns
+// joined by spaces, or every tab replaced by a space. src maps each displayed rune back to its
+// rune index in text, or -1 for inserted separators
+func displayRunes(text string, columns []int) (disp []rune, src []int) {
+	runes := []rune(text)
+	if len(columns) == 0 {
+		disp = make([]rune, len(runes))
+		src = make([]int, len(runes))
+		for i, r := range runes {
+			if r == '\t' {
+				r = ' '
+			}
+			disp[i], src[i] = r, i
+		}
+		return disp, src
 	}
 
-	var r io.Reader
-	switch kind {
-	case markerImageData:
-		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(payload))
-	case markerImagePath:
-		f, err := os.Open(payload)
-		if err != nil {
-			return nil, err
+	var fields [][2]int
+	var start int
+	for i, r := range runes {
+		if r == '\t' {
+			fields = append(fields, [2]int{start, i})
+			start = i + 1
 		}
-		defer f.Close()
+	}
+	fields = append(fields, [2]int{start, len(runes)})
+
+	for _, c := range columns {
+		if c < 1 || c > len(fields) {
+			continue
+		}
+		if len(disp) > 0 {
+			disp = append(disp, ' ')
+			src = append(src, -1)
+		}
+		for j := fields[c-1][0]; j < fields[c-1][1]; j++ {
+			disp = append(disp, runes[j])
+			src = append(src, j)
+		}
+	}
+	return disp, src
+}
+
+// drawScrollbar draws a one column track with a thumb sized and placed by the visible share of total lines
+func drawScrollbar(win vaxis.Window, offset, total int) {
+	_, h := win.Size()
+	if h == 0 || total <= h {
+		return
+	}
+	size := max(h*h/total, 1)
+	pos := offset * (h - size) / (total - h)
+	for row := range h {
+		cell := vaxis.Cell{Character: vaxis.Character{Grapheme: "│", Width: 1}, Style: vaxis.Style{Foreground: vaxis.ColorBlack}}
+		if row >= pos && row < pos+size {
+			cell = vaxis.Cell{Character: vaxis.Character{Grapheme: "┃", Width: 1}}
+		}
+		win.SetCell(0, row, cell)
+	}
+}
+
+type preview struct {
+	text string
+	img  image.Image
+	err  error
+}
+
+func parsePreview(out []byte) (*preview, error) {
+	kind, payload, _, ok := cutOSC(string(out))
+	if !ok {
+		return &preview{text: string(out)}, nil
+	}
+
+	var r io.Reader
+	switch kind {
+	case markerImageData:
+		r = base64.NewDecoder(base64.StdEncoding, strings.NewReader(payload))
+	case markerImagePath:
+		f, err := os.Open(payload)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
 		r = f
 	default:
 		return &preview{text: string(out)}, nil
@@ -594,6 +1255,10 @@ func (st *imageState) draw(win vaxis.Window, vx *vaxis.Vaxis, pv *preview) {
 		if st.cur != nil {
 			st.cur.Draw(win)
 		}
+	} else if pv != nil && pv.err != nil {
+		for i, text := range errorLines(pv.err) {
+			win.Println(i, vaxis.Segment{Text: text, Style: vaxis.Style{Foreground: vaxis.IndexColor(1)}})
+		}
 	} else if pv != nil {
 		win.Print(styledSegments(vx, pv.text)...)
 	}
@@ -626,7 +1291,7 @@ func styledSegments(vx *vaxis.Vaxis, s string) []vaxis.Segment {
 	return segs
 }
 
-func drawFooter(win vaxis.Window, conf config, visScripts []string) {
+func drawFooter(win vaxis.Window, conf config, visScripts []string, actions []scriptAction) {
 	footSegs := make([]vaxis.Segment, 0, len(conf.Scripts)*2)
 	footSegs = append(footSegs, vaxis.Segment{Text: "# ", Style: vaxis.Style{Foreground: vaxis.ColorBlack}})
 
@@ -641,6 +1306,19 @@ func drawFooter(win vaxis.Window, conf config, visScripts []string) {
 		footSegs = append(footSegs, vaxis.Segment{Text: sconf.Name, Style: style})
 	}
 
+	// actions of the active script
+	for i, act := range actions {
+		sep := "  "
+		if i == 0 {
+			sep = " │ "
+		}
+		footSegs = append(footSegs,
+			vaxis.Segment{Text: sep, Style: vaxis.Style{Foreground: vaxis.ColorBlack}},
+			vaxis.Segment{Text: act.Key, Style: vaxis.Style{Foreground: vaxis.ColorBlack}},
+			vaxis.Segment{Text: " " + act.Name},
+		)
+	}
+
 	win.Println(0, footSegs...)
 }
 
@@ -652,20 +1330,129 @@ type script struct {
 	executing  atomic.Bool
 	lastLoaded time.Time
 	lastQuery  string
-	lines      []string
+	items      []listItem
+	err        error // last failure, cleared by the next successful load
+	confErr    error // problems with the script's config, so it isn't run
+	logs       []logEntry
+	stdin      bool // lines are read from stdin by loadStdin, the script isn't run
+	stale      bool // lines are from the cache, and the script is still running
 
 	previewResult *preview
 	previewLine   string
 }
 
-func loadScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *script, query string) error {
+// logEntry is an invocation of a script that failed or wrote to stderr
+type logEntry struct {
+	mode     string
+	at       time.Time
+	exitCode int // -1 if the script didn't exit normally
+	stderr   string
+}
+
+// wrapLine splits text into pieces that each fit in width cells
+func wrapLine(vx *vaxis.Vaxis, text string, width int) []string {
+	var parts []string
+	var part strings.Builder
+	var w int
+	for _, c := range vaxis.Characters(text) {
+		cw := vx.RenderedWidth(c.Grapheme)
+		if w+cw > width && w > 0 {
+			parts = append(parts, part.String())
+			part.Reset()
+			w = 0
+		}
+		part.WriteString(c.Grapheme)
+		w += cw
+	}
+	return append(parts, part.String())
+}
+
+// how many invocations each script keeps in its log
+const logSize = 16
+
+// logInvocation records an invocation in the script's log if it failed or wrote to stderr, and has vx redraw
+func (sc *script) logInvocation(vx *vaxis.Vaxis, mode string, err error, stderr *tailBuffer) {
+	if err == nil && len(stderr.buf) == 0 {
+		return
+	}
+	var exitCode int
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+
+	sc.mu.Lock()
+	sc.logs = append(sc.logs, logEntry{mode: mode, at: time.Now(), exitCode: exitCode, stderr: stderr.String()})
+	if over := len(sc.logs) - logSize; over > 0 {
+		sc.logs = slices.Delete(sc.logs, 0, over)
+	}
+	sc.mu.Unlock()
+
+	if vx != nil {
+		vx.PostEvent(eventLogged{})
+	}
+}
+
+// drawLog draws the script's log newest first, a header line per invocation followed by its stderr
+func drawLog(win vaxis.Window, sc *script) {
+	sc.mu.Lock()
+	logs := slices.Clone(sc.logs)
+	sc.mu.Unlock()
+
+	w, h := win.Size()
+	if len(logs) == 0 {
+		win.Println(0, vaxis.Segment{Text: "no stderr from " + sc.Name, Style: vaxis.Style{Foreground: vaxis.ColorBlack}})
+		return
+	}
+
+	var row int
+	for _, entry := range slices.Backward(logs) {
+		if row >= h {
+			break
+		}
+		exitStyle := vaxis.Style{Foreground: vaxis.ColorBlack}
+		if entry.exitCode != 0 {
+			exitStyle.Foreground = vaxis.IndexColor(1)
+		}
+		win.Println(row,
+			vaxis.Segment{Text: entry.at.Format(time.TimeOnly) + " " + entry.mode, Style: vaxis.Style{Foreground: vaxis.ColorBlack}},
+			vaxis.Segment{Text: fmt.Sprintf(" exit %d", entry.exitCode), Style: exitStyle},
+		)
+		row++
+		for text := range strings.Lines(strings.TrimRight(entry.stderr, "\n")) {
+			for _, part := range wrapLine(win.Vx, strings.TrimRight(text, "\n"), w) {
+				if row >= h {
+					break
+				}
+				win.Println(row, vaxis.Segment{Text: part})
+				row++
+			}
+		}
+	}
+}
+
+func loadScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *script, query string) (err error) {
+	if sc.confErr != nil {
+		return sc.confErr
+	}
 	ctx, gen, ok := sc.load.take(ctx, query)
 	if !ok {
 		return nil
 	}
 	defer sc.load.release(gen)
 
-	ctx, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	stderr := &tailBuffer{max: stderrTailSize}
+	defer func() {
+		stderr.collect()
+		sc.logInvocation(vx, modeList, err, stderr)
+		if err != nil {
+			err = &scriptError{mode: modeList, err: err, stderr: stderr.String()}
+		}
+	}()
+
+	timeout := orDefaultTimeout(sc.ListTimeout)
+	ctx, cancelTimeout := withTimeout(ctx, timeout)
 	defer cancelTimeout()
 
 	if spinner != nil {
@@ -675,7 +1462,52 @@ func loadScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *scri
 
 	start := time.Now()
 
-	cmd := makeCmd(ctx, sc, modeList, query, "")
+	// publish hands lines read so far to the event loop. on a reload the previous lines stay up until
+	// the new ones catch up with them, so the list doesn't shrink and regrow
+	publish := func(lines []string, done bool) {
+		items := parseItems(sc.Format, lines)
+		vx.SyncFunc(func() {
+			if !sc.load.current(gen) {
+				return
+			}
+			sc.mu.Lock()
+			defer sc.mu.Unlock()
+			// cached lines always go once the script is done, even if it listed nothing
+			if len(items) > 0 && (done || len(items) >= len(sc.items)) || done && sc.stale {
+				sc.items = items
+				sc.stale = false
+			}
+			if done {
+				sc.lastLoaded = time.Now()
+				sc.lastQuery = query
+				sc.err = nil
+			}
+		})
+	}
+
+	// show what the script listed last time for this query while it runs, unless it's already showing that
+	if sc.CacheTTL > 0 {
+		sc.mu.Lock()
+		showing := !sc.lastLoaded.IsZero() && sc.lastQuery == query
+		sc.mu.Unlock()
+		if cached, ok := readCache(sc.Name, query, sc.CacheTTL); ok && !showing {
+			items := parseItems(sc.Format, cached)
+			vx.SyncFunc(func() {
+				if !sc.load.current(gen) {
+					return
+				}
+				sc.mu.Lock()
+				defer sc.mu.Unlock()
+				sc.items = items
+				sc.stale = true
+			})
+		}
+	}
+
+	cmd := makeCmd(ctx, sc, modeList, query, nil)
+	if err := stderr.attach(cmd); err != nil {
+		return err
+	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -684,42 +1516,301 @@ func loadScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *scri
 		return err
 	}
 
-	var lines []string
-	bs := bufio.NewScanner(stdout)
-	for bs.Scan() {
-		lines = append(lines, bs.Text())
-	}
-	if err := bs.Err(); err != nil {
+	lines, err := streamLines(stdout, func(lines []string) { publish(lines, false) })
+	if err != nil {
 		return err
 	}
 	if err := cmd.Wait(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %v", errTimeout, timeout)
+		}
 		if ctx.Err() != nil {
 			return nil
 		}
 		return err
 	}
 
+	if sc.CacheTTL > 0 {
+		if err := writeCache(sc.Name, query, lines); err != nil {
+			slog.ErrorContext(ctx, "write cache", "script", sc.Name, "error", err)
+		}
+	}
+
 	slog.InfoContext(ctx, "loaded script", "script", sc.Name, "num_lines", len(lines), "took_ms", time.Since(start).Milliseconds())
 
-	vx.SyncFunc(func() {
-		if !sc.load.current(gen) {
-			return
+	publish(lines, true)
+
+	return nil
+}
+
+// loadStdin has the signature of loadScript, but reads lines piped to cmenu into sc rather than running it
+func loadStdin(_ context.Context, vx *vaxis.Vaxis, _ *spinner, sc *script, _ string) error {
+	publish := func(lines []string, done bool) {
+		items := parseItems("", lines)
+		vx.SyncFunc(func() {
+			sc.mu.Lock()
+			defer sc.mu.Unlock()
+			sc.items = items
+			if done {
+				sc.lastLoaded = time.Now()
+			}
+		})
+	}
+
+	lines, err := streamLines(os.Stdin, func(lines []string) { publish(lines, false) })
+	if err != nil {
+		return err
+	}
+	publish(lines, true)
+	return nil
+}
+
+// cachePath is where a script's lines for a query are cached, under $XDG_CACHE_HOME/cmenu
+func cachePath(scriptName, query string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(query))
+	return filepath.Join(cacheDir, "cmenu", url.PathEscape(scriptName)+"-"+hex.EncodeToString(sum[:8])), nil
+}
+
+// readCache returns the cached lines if they're younger than ttl
+func readCache(scriptName, query string, ttl time.Duration) ([]string, bool) {
+	path, err := cachePath(scriptName, query)
+	if err != nil {
+		return nil, false
+	}
+	fi, err := os.Stat(path)
+	if err != nil || time.Since(fi.ModTime()) > ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), true
+}
+
+func writeCache(scriptName, query string, lines []string) error {
+	path, err := cachePath(scriptName, query)
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	for _, line := range lines {
+		data.WriteString(line)
+		data.WriteByte('\n')
+	}
+	return writeFileAtomic(path, data.Bytes())
+}
+
+// writeFileAtomic writes data to path, making its directory if needed. it writes then renames, so a
+// concurrent cmenu never reads half a file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// history remembers when lines were picked, to rank scripts with sort = "frecency"
+type history struct {
+	mu    sync.Mutex
+	path  string
+	items map[historyKey]*historyItem
+}
+
+type historyKey struct{ script, id string }
+
+type historyItem struct {
+	Script string  `json:"script"`
+	ID     string  `json:"id"`
+	Count  int     `json:"count"`
+	Visits []int64 `json:"visits"` // unix times of the latest picks, oldest first
+}
+
+// how many picks of a line are kept to weigh by age. older ones only count towards Count
+const historyVisits = 10
+
+// historyPath is where picks are remembered, under $XDG_STATE_HOME/cmenu
+func historyPath() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
 		}
-		sc.mu.Lock()
-		defer sc.mu.Unlock()
-		if len(lines) > 0 {
-			sc.lines = lines
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateDir, "cmenu", "history.json"), nil
+}
+
+// loadHistory reads the history at path. a missing file is an empty history
+func loadHistory(path string) (*history, error) {
+	h := &history{path: path, items: map[historyKey]*historyItem{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []*historyItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("parse %q: %w", path, err)
+	}
+	for _, item := range items {
+		h.items[historyKey{item.Script, item.ID}] = item
+	}
+	return h, nil
+}
+
+// record remembers that the items with ids were picked from a script now
+func (h *history) record(scriptName string, ids []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now().Unix()
+	for _, id := range ids {
+		k := historyKey{scriptName, id}
+		item, ok := h.items[k]
+		if !ok {
+			item = &historyItem{Script: scriptName, ID: id}
+			h.items[k] = item
+		}
+		item.Count++
+		item.Visits = append(item.Visits, now)
+		if len(item.Visits) > historyVisits {
+			item.Visits = item.Visits[len(item.Visits)-historyVisits:]
 		}
-		sc.lastLoaded = time.Now()
-		sc.lastQuery = query
+	}
+	return h.save()
+}
+
+// forget drops an item from the history, reporting if it was there
+func (h *history) forget(scriptName, id string) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := historyKey{scriptName, id}
+	if _, ok := h.items[k]; !ok {
+		return false, nil
+	}
+	delete(h.items, k)
+	return true, h.save()
+}
+
+// scores returns the frecency of each remembered item of a script, by id
+func (h *history) scores(scriptName string) map[string]float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	scores := map[string]float64{}
+	for k, item := range h.items {
+		if k.script == scriptName {
+			scores[k.id] = frecency(item, now)
+		}
+	}
+	return scores
+}
+
+// frecency weighs the kept picks by age, then scales up to every pick, like firefox's url bar
+func frecency(item *historyItem, now time.Time) float64 {
+	if len(item.Visits) == 0 {
+		return 0
+	}
+	var total float64
+	for _, visit := range item.Visits {
+		switch age := now.Sub(time.Unix(visit, 0)); {
+		case age < 4*24*time.Hour:
+			total += 100
+		case age < 14*24*time.Hour:
+			total += 70
+		case age < 31*24*time.Hour:
+			total += 50
+		case age < 90*24*time.Hour:
+			total += 30
+		default:
+			total += 10
+		}
+	}
+	return total * float64(item.Count) / float64(len(item.Visits))
+}
+
+// save writes the history, called with mu held
+func (h *history) save() error {
+	if h.path == "" {
+		return nil
+	}
+	items := slices.SortedFunc(maps.Values(h.items), func(a, b *historyItem) int {
+		return cmp.Or(cmp.Compare(a.Script, b.Script), cmp.Compare(a.ID, b.ID))
 	})
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.path, data)
+}
 
-	return nil
+// how often lines from a running list script are handed to the event loop
+const streamInterval = 50 * time.Millisecond
+
+// streamLines reads r to the end, handing the lines read so far to publish every streamInterval
+func streamLines(r io.Reader, publish func(lines []string)) ([]string, error) {
+	var mu sync.Mutex
+	var lines []string
+	scanned := make(chan error, 1)
+	go func() {
+		bs := bufio.NewScanner(r)
+		for bs.Scan() {
+			mu.Lock()
+			lines = append(lines, bs.Text())
+			mu.Unlock()
+		}
+		scanned <- bs.Err()
+	}()
+
+	// batches are capped so the scanner's later appends never write into a published one
+	batch := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return lines[:len(lines):len(lines)]
+	}
+
+	ticker := time.NewTicker(streamInterval)
+	defer ticker.Stop()
+
+	var published int
+	for {
+		select {
+		case err := <-scanned:
+			return batch(), err
+		case <-ticker.C:
+			if b := batch(); len(b) > published {
+				publish(b)
+				published = len(b)
+			}
+		}
+	}
 }
 
-func execScript(parent context.Context, spinner *spinner, sc *script, query, text string) error {
+// execScript runs sc with items, once per item or once with them all depending on sc.Multi, and returns
+// what it wrote to stdout if output is set. if action is set the script runs in action mode with CMENU_ACTION,
+// rather than run mode
+func execScript(parent context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *script, query, action string, items []string, output bool) ([]byte, error) {
 	if !sc.executing.CompareAndSwap(false, true) {
-		return nil
+		return nil, nil
 	}
 	defer sc.executing.Store(false)
 
@@ -728,10 +1819,57 @@ func execScript(parent context.Context, spinner *spinner, sc *script, query, tex
 		defer spinner.stop()
 	}
 
-	ctx, cancel := context.WithTimeout(parent, 30*time.Second)
-	defer cancel()
+	// each invocation gets the whole timeout, so marking many lines doesn't make the last ones time out
+	timeout := orDefaultTimeout(sc.RunTimeout)
+
+	mode := modeRun
+	var env []string
+	if action != "" {
+		mode = modeAction
+		env = append(env, "CMENU_ACTION="+action)
+	}
+
+	var stdout bytes.Buffer
+	run := func(items []string, env ...string) error {
+		ctx, cancel := withTimeout(parent, timeout)
+		defer cancel()
+		stderr := &tailBuffer{max: stderrTailSize}
+		cmd := makeCmd(ctx, sc, mode, query, items, env...)
+		// stdout is left alone unless it's wanted, so whatever the script starts doesn't hold a pipe open
+		if output {
+			cmd.Stdout = &stdout
+		}
+		if err := stderr.attach(cmd); err != nil {
+			return &scriptError{mode: mode, err: err}
+		}
+		err := cmd.Run()
+		stderr.collect()
+		// the script exited 0, but something it started still holds its output open
+		if errors.Is(err, exec.ErrWaitDelay) {
+			err = nil
+		}
+		sc.logInvocation(vx, mode, err, stderr)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %v", errTimeout, timeout)
+		}
+		if err != nil {
+			return &scriptError{mode: mode, err: err, stderr: stderr.String()}
+		}
+		return nil
+	}
 
-	return makeCmd(ctx, sc, modeRun, query, text).Run()
+	if sc.Multi == multiBatch {
+		if err := run(items, append(env, "CMENU_ITEMS="+strings.Join(items, "\n"))...); err != nil {
+			return nil, err
+		}
+		return stdout.Bytes(), nil
+	}
+	for _, item := range items {
+		if err := run([]string{item}, append(env, "CMENU_ITEM="+item)...); err != nil {
+			return nil, err
+		}
+	}
+	return stdout.Bytes(), nil
 }
 
 func previewScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *script, query, line string, cols, rows int) error {
@@ -741,7 +1879,8 @@ func previewScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *s
 	}
 	defer sc.preview.release(gen)
 
-	ctx, cancelTimeout := context.WithTimeout(ctx, 30*time.Second)
+	timeout := orDefaultTimeout(sc.PreviewTimeout)
+	ctx, cancelTimeout := withTimeout(ctx, timeout)
 	defer cancelTimeout()
 
 	if spinner != nil {
@@ -749,20 +1888,35 @@ func previewScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *s
 		defer spinner.stop()
 	}
 
-	out, err := makeCmd(ctx, sc, modePreview, query, line,
+	stderr := &tailBuffer{max: stderrTailSize}
+	cmd := makeCmd(ctx, sc, modePreview, query, []string{line},
+		"CMENU_ITEM="+line,
 		fmt.Sprintf("CMENU_PREVIEW_COLS=%d", cols),
 		fmt.Sprintf("CMENU_PREVIEW_LINES=%d", rows),
-	).Output()
-	if err != nil {
-		if ctx.Err() != nil {
-			return nil
-		}
+	)
+	if err := stderr.attach(cmd); err != nil {
 		return err
 	}
+	out, err := cmd.Output()
+	stderr.collect()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %v", errTimeout, timeout)
+	} else if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	sc.logInvocation(vx, modePreview, err, stderr)
 
-	pv, err := parsePreview(out)
+	// failures are shown in the preview pane
+	var pv *preview
+	if err == nil {
+		pv, err = parsePreview(out)
+	}
 	if err != nil {
-		return err
+		err = &scriptError{mode: modePreview, err: err, stderr: stderr.String()}
+		pv = &preview{err: err}
 	}
 
 	vx.SyncFunc(func() {
@@ -775,43 +1929,155 @@ func previewScript(ctx context.Context, vx *vaxis.Vaxis, spinner *spinner, sc *s
 		sc.previewLine = line
 	})
 
-	return nil
+	return err
+}
+
+// scriptError is a failed script invocation with the end of what it wrote to stderr
+type scriptError struct {
+	mode   string
+	err    error
+	stderr string
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("%s: %v", e.mode, e.err)
+}
+
+func (e *scriptError) Unwrap() error {
+	return e.err
 }
 
+// how much of a failed script's stderr to keep, and show
 const (
-	modeList    = "list"
-	modeRun     = "run"
-	modePreview = "preview"
+	stderrTailSize  = 4 << 10
+	stderrTailLines = 3
 )
 
-func makeCmd(ctx context.Context, sc *script, mode, query, line string, extraEnv ...string) *exec.Cmd {
-	var args []string
-	if line != "" {
-		args = append(args, line)
+// errorLines formats err for display, followed by the last few lines of stderr if it's a scriptError
+func errorLines(err error) []string {
+	var lines []string
+	for line := range strings.Lines(err.Error()) {
+		lines = append(lines, "✗ "+strings.TrimSuffix(line, "\n"))
 	}
-	cmd := exec.CommandContext(ctx, sc.Path, args...)
-	cmd.Env = append(cmd.Environ(), "CMENU_MODE="+mode, "CMENU_INPUT="+query)
-	cmd.Env = append(cmd.Env, extraEnv...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM) }
-	cmd.WaitDelay = 100 * time.Millisecond
-	return cmd
+	var serr *scriptError
+	if errors.As(err, &serr) {
+		tail := strings.Split(strings.TrimSpace(serr.stderr), "\n")
+		tail = slices.DeleteFunc(tail, func(s string) bool { return strings.TrimSpace(s) == "" })
+		lines = append(lines, tail[max(len(tail)-stderrTailLines, 0):]...)
+	}
+	return lines
 }
 
-func clamp[T cmp.Ordered](v, mn, mx T) T {
-	v = max(v, mn)
-	v = min(v, mx)
-	return v
+// tailBuffer keeps the last max bytes a script writes to stderr. the script writes to an unlinked temporary
+// file rather than the pipe exec makes for an io.Writer, so processes it leaves running, like a launched
+// app, don't hold cmd.Wait up until WaitDelay
+type tailBuffer struct {
+	max  int
+	file *os.File
+	buf  []byte
 }
 
-// parseInput splits input like "cc [1+3] 4" into scriptQuery "1+3" and filterQuery "cc 4"
-func parseInput(s string) (scriptQuery, filterQuery string) {
-	open := strings.Index(s, "[")
-	if open < 0 {
-		return "", s
+// attach points cmd's stderr at a new temporary file
+func (t *tailBuffer) attach(cmd *exec.Cmd) error {
+	f, err := os.CreateTemp("", "cmenu-stderr-*")
+	if err != nil {
+		return err
 	}
-	cl := strings.Index(s[open:], "]")
-	if cl < 0 {
+	os.Remove(f.Name())
+	t.file = f
+	cmd.Stderr = f
+	return nil
+}
+
+// collect reads the end of the file once the script has exited, and closes it
+func (t *tailBuffer) collect() {
+	if t.file == nil {
+		return
+	}
+	defer t.file.Close()
+	if fi, err := t.file.Stat(); err == nil {
+		off := max(fi.Size()-int64(t.max), 0)
+		t.buf = make([]byte, fi.Size()-off)
+		n, _ := t.file.ReadAt(t.buf, off)
+		t.buf = t.buf[:n]
+	}
+	t.file = nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
+
+// timeout for script invocations that don't configure one
+const defaultTimeout = 30 * time.Second
+
+var errTimeout = errors.New("timed out")
+
+func orDefaultTimeout(d *time.Duration) time.Duration {
+	if d == nil {
+		return defaultTimeout
+	}
+	return *d
+}
+
+// withTimeout is context.WithTimeout, but a timeout of 0 means none
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+const (
+	modeList    = "list"
+	modeRun     = "run"
+	modePreview = "preview"
+	modeAction  = "action"
+)
+
+// name of the script made of lines piped to cmenu
+const stdinScriptName = "stdin"
+
+// how a script runs multiple marked lines
+const (
+	multiEach  = "each"  // once per line, with the line as the argument and in CMENU_ITEM. the default
+	multiBatch = "batch" // once with every line as arguments, and newline separated in CMENU_ITEMS
+)
+
+// how a script's lines are read
+const (
+	formatJSONL = "jsonl" // a json object per line, see jsonItem. the default is plain text with markers
+)
+
+// how a script's lines are ordered when there's no filter
+const (
+	sortFrecency = "frecency" // most often and recently picked first, remembered in the history
+)
+
+func makeCmd(ctx context.Context, sc *script, mode, query string, args []string, extraEnv ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, sc.Path, args...)
+	cmd.Env = append(cmd.Environ(), "CMENU_MODE="+mode, "CMENU_INPUT="+query)
+	cmd.Env = append(cmd.Env, extraEnv...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM) }
+	cmd.WaitDelay = 100 * time.Millisecond
+	return cmd
+}
+
+func clamp[T cmp.Ordered](v, mn, mx T) T {
+	v = max(v, mn)
+	v = min(v, mx)
+	return v
+}
+
+// parseInput splits input like "cc [1+3] 4" into scriptQuery "1+3" and filterQuery "cc 4"
+func parseInput(s string) (scriptQuery, filterQuery string) {
+	open := strings.Index(s, "[")
+	if open < 0 {
+		return "", s
+	}
+	cl := strings.Index(s[open:], "]")
+	if cl < 0 {
 		return "", s
 	}
 	cl += open
@@ -820,14 +2086,337 @@ func parseInput(s string) (scriptQuery, filterQuery string) {
 	return scriptQuery, filterQuery
 }
 
-func match(text, s string) bool {
-	return strings.Contains(strings.ToLower(text), strings.ToLower(s))
+// query is a parsed filter like "foo 'bar ^baz qux$ !quux a | b @2:c". space separated terms are
+// ANDed, terms joined by "|" are ORed within one group, and "@N:" scopes a term to tab separated column N
+type query [][]term
+
+type termKind uint8
+
+const (
+	termFuzzy  termKind = iota // foo
+	termExact                  // 'foo
+	termPrefix                 // ^foo
+	termSuffix                 // foo$
+	termEqual                  // ^foo$
+)
+
+type term struct {
+	kind    termKind
+	inverse bool // !foo, matches lines not containing foo. implies exact unless another kind is given
+	column  int  // @N:foo, 1 indexed column to match in, or 0 for the script's filter columns
+	text    []rune
+}
+
+func parseQuery(s string) query {
+	var q query
+	var or bool
+	for _, tok := range strings.Fields(s) {
+		if tok == "|" {
+			or = len(q) > 0
+			continue
+		}
+		t, ok := parseTerm(tok)
+		if !ok {
+			continue
+		}
+		if or {
+			q[len(q)-1] = append(q[len(q)-1], t)
+		} else {
+			q = append(q, []term{t})
+		}
+		or = false
+	}
+	return q
+}
+
+func parseTerm(tok string) (term, bool) {
+	var t term
+	if col, rest, ok := strings.Cut(tok, ":"); ok && strings.HasPrefix(col, "@") {
+		if n, err := strconv.Atoi(col[1:]); err == nil && n > 0 {
+			t.column = n
+			tok = rest
+		}
+	}
+	if rest, ok := strings.CutPrefix(tok, "!"); ok {
+		t.inverse = true
+		tok = rest
+	}
+	if rest, ok := strings.CutPrefix(tok, "'"); ok {
+		t.kind = termExact
+		tok = rest
+	} else if rest, ok := strings.CutPrefix(tok, "^"); ok {
+		t.kind = termPrefix
+		tok = rest
+	}
+	if rest, ok := strings.CutSuffix(tok, "$"); ok && t.kind != termExact {
+		if t.kind == termPrefix {
+			t.kind = termEqual
+		} else {
+			t.kind = termSuffix
+		}
+		tok = rest
+	}
+	if t.inverse && t.kind == termFuzzy {
+		t.kind = termExact
+	}
+	if tok == "" {
+		return term{}, false
+	}
+	t.text = lowerRunes(tok)
+	return t, true
+}
+
+// match reports whether text satisfies every group of q, returning a summed score for ranking
+// and the sorted rune positions in text that matched. unscoped terms only see the given 1 indexed
+// columns of text, or all of it if there are none
+func (q query) match(text string, columns []int) (score int, positions []int, ok bool) {
+	if len(q) == 0 {
+		return 0, nil, true
+	}
+	var scoped []rune
+	var src []int // nil when scoped is text itself
+	if len(columns) > 0 {
+		scoped, src = displayRunes(text, columns)
+	} else {
+		scoped = []rune(text)
+	}
+	for _, group := range q {
+		var matched bool
+		for _, t := range group {
+			tText, tSrc := scoped, src
+			if t.column > 0 {
+				tText, tSrc = displayRunes(text, []int{t.column})
+			}
+			s, pos, ok := t.match(tText)
+			if !ok {
+				continue
+			}
+			score += s
+			for _, p := range pos {
+				if tSrc != nil {
+					p = tSrc[p]
+				}
+				if p >= 0 {
+					positions = append(positions, p)
+				}
+			}
+			matched = true
+			break
+		}
+		if !matched {
+			return 0, nil, false
+		}
+	}
+	slices.Sort(positions)
+	return score, slices.Compact(positions), true
+}
+
+func (t term) match(text []rune) (score int, positions []int, ok bool) {
+	if t.kind == termFuzzy {
+		score, positions, ok = fuzzyMatch(text, t.text)
+	} else {
+		score, positions, ok = exactMatch(text, t.text, t.kind)
+	}
+	if t.inverse {
+		return 0, nil, !ok
+	}
+	return score, positions, ok
+}
+
+// fuzzy scoring follows fzf's v1 algorithm. matching chars score points, gaps between them
+// cost points, and chars at word boundaries, camelCase humps, and in consecutive runs earn bonuses
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	bonusBoundary    = scoreMatch / 2
+	bonusNonWord     = scoreMatch / 2
+	bonusCamel       = bonusBoundary + scoreGapExtension
+	bonusConsecutive = -(scoreGapStart + scoreGapExtension)
+	bonusFirstChar   = 2 // multiplier for the bonus of the first pattern char
+)
+
+type charClass uint8
+
+const (
+	charNonWord charClass = iota
+	charLower
+	charUpper
+	charNumber
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsLetter(r):
+		return charLower
+	case unicode.IsDigit(r):
+		return charNumber
+	}
+	return charNonWord
+}
+
+func bonusFor(prev, cur charClass) int {
+	switch {
+	case prev == charNonWord && cur != charNonWord:
+		return bonusBoundary
+	case prev == charLower && cur == charUpper, prev != charNumber && cur == charNumber:
+		return bonusCamel
+	case cur == charNonWord:
+		return bonusNonWord
+	}
+	return 0
+}
+
+// fuzzyMatch finds pattern as a subsequence of text. it takes the first match scanning forward,
+// then scans back from its end to tighten the start, and scores that window. pattern must be lowercase
+func fuzzyMatch(text, pattern []rune) (score int, positions []int, ok bool) {
+	if len(pattern) == 0 {
+		return 0, nil, true
+	}
+
+	sidx, eidx := -1, -1
+	for i, pi := 0, 0; i < len(text); i++ {
+		if unicode.ToLower(text[i]) != pattern[pi] {
+			continue
+		}
+		if sidx < 0 {
+			sidx = i
+		}
+		if pi++; pi == len(pattern) {
+			eidx = i + 1
+			break
+		}
+	}
+	if eidx < 0 {
+		return 0, nil, false
+	}
+	for i, pi := eidx-1, len(pattern)-1; i >= sidx; i-- {
+		if unicode.ToLower(text[i]) != pattern[pi] {
+			continue
+		}
+		if pi--; pi < 0 {
+			sidx = i
+			break
+		}
+	}
+
+	score, positions = scoreWindow(text, pattern, sidx, eidx)
+	return score, positions, true
+}
+
+// exactMatch finds pattern as a contiguous run in text, anchored depending on kind. pattern must be lowercase
+func exactMatch(text, pattern []rune, kind termKind) (score int, positions []int, ok bool) {
+	idx := -1
+	switch kind {
+	case termExact:
+		for i := 0; i+len(pattern) <= len(text); i++ {
+			if hasAt(text, pattern, i) {
+				idx = i
+				break
+			}
+		}
+	case termPrefix:
+		if hasAt(text, pattern, 0) {
+			idx = 0
+		}
+	case termSuffix:
+		if i := len(text) - len(pattern); i >= 0 && hasAt(text, pattern, i) {
+			idx = i
+		}
+	case termEqual:
+		if len(text) == len(pattern) && hasAt(text, pattern, 0) {
+			idx = 0
+		}
+	}
+	if idx < 0 {
+		return 0, nil, false
+	}
+	score, positions = scoreWindow(text, pattern, idx, idx+len(pattern))
+	return score, positions, true
+}
+
+func hasAt(text, pattern []rune, i int) bool {
+	if i < 0 || i+len(pattern) > len(text) {
+		return false
+	}
+	for j, r := range pattern {
+		if unicode.ToLower(text[i+j]) != r {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreWindow scores the match of pattern in text[sidx:eidx], which must contain it as a subsequence
+func scoreWindow(text, pattern []rune, sidx, eidx int) (score int, positions []int) {
+	positions = make([]int, 0, len(pattern))
+	prevClass := charNonWord
+	if sidx > 0 {
+		prevClass = classOf(text[sidx-1])
+	}
+	var pi, consecutive, firstBonus int
+	var inGap bool
+	for i := sidx; i < eidx; i++ {
+		class := classOf(text[i])
+		if pi < len(pattern) && unicode.ToLower(text[i]) == pattern[pi] {
+			score += scoreMatch
+			bonus := bonusFor(prevClass, class)
+			if consecutive == 0 {
+				firstBonus = bonus
+			} else {
+				// a run keeps the boundary bonus of the char that started it
+				if bonus >= bonusBoundary && bonus > firstBonus {
+					firstBonus = bonus
+				}
+				bonus = max(bonus, firstBonus, bonusConsecutive)
+			}
+			if pi == 0 {
+				score += bonus * bonusFirstChar
+			} else {
+				score += bonus
+			}
+			positions = append(positions, i)
+			inGap = false
+			consecutive++
+			pi++
+		} else {
+			if inGap {
+				score += scoreGapExtension
+			} else {
+				score += scoreGapStart
+			}
+			inGap = true
+			consecutive = 0
+			firstBonus = 0
+		}
+		prevClass = class
+	}
+	return score, positions
+}
+
+func lowerRunes(s string) []rune {
+	rs := []rune(s)
+	for i, r := range rs {
+		rs[i] = unicode.ToLower(r)
+	}
+	return rs
 }
 
 type lineStyle struct {
 	highlight bool
 	stay      bool
 	label     bool
+	error     bool // not from a marker, set on the lines cmenu adds for a failed script
+
+	fg, bg        vaxis.Color // unset is the terminal's
+	dim           bool
+	italic        bool
+	strikethrough bool
+	right         string // drawn at the right edge, like a timestamp or size
+	icon          string // drawn in a column before the text, like an emoji or nerd font glyph
 }
 
 // escape code is 6366, or the first 4 numbers of ASCII "cmenu" in hex
@@ -836,11 +2425,19 @@ const oscTerm = "\x07"
 
 // marker kinds shared between the emit subcommands in main and the parsers
 const (
-	markerHighlight = "highlight"
-	markerStay      = "stay"
-	markerLabel     = "label"
-	markerImageData = "image-data"
-	markerImagePath = "image-path"
+	markerHighlight     = "highlight"
+	markerStay          = "stay"
+	markerLabel         = "label"
+	markerID            = "id"
+	markerFg            = "fg"
+	markerBg            = "bg"
+	markerDim           = "dim"
+	markerItalic        = "italic"
+	markerStrikethrough = "strikethrough"
+	markerRight         = "right"
+	markerIcon          = "icon"
+	markerImageData     = "image-data"
+	markerImagePath     = "image-path"
 )
 
 func cutOSC(s string) (kind, payload, rest string, ok bool) {
@@ -856,10 +2453,11 @@ func cutOSC(s string) (kind, payload, rest string, ok bool) {
 	return kind, payload, rest, true
 }
 
-func parseLineStyle(raw string) (text string, style lineStyle) {
+// parseLineStyle strips the markers from the start of raw. id is from an id marker, if there is one
+func parseLineStyle(raw string) (text string, style lineStyle, id string) {
 	text = raw
 	for {
-		kind, _, rest, ok := cutOSC(text)
+		kind, payload, rest, ok := cutOSC(text)
 		if !ok {
 			break
 		}
@@ -871,9 +2469,293 @@ func parseLineStyle(raw string) (text string, style lineStyle) {
 			style.stay = true
 		case markerLabel:
 			style.label = true
+		case markerID:
+			id = payload
+		case markerFg:
+			style.fg, _ = parseColour(payload)
+		case markerBg:
+			style.bg, _ = parseColour(payload)
+		case markerDim:
+			style.dim = true
+		case markerItalic:
+			style.italic = true
+		case markerStrikethrough:
+			style.strikethrough = true
+		case markerRight:
+			style.right = payload
+		case markerIcon:
+			style.icon = payload
+		}
+	}
+	return text, style, id
+}
+
+// parseSGR strips ANSI escapes from s, like the colours from ls --color, so they're not shown or matched.
+// styles has the style of each rune of text from the SGR escapes and OSC 8 hyperlinks, and is nil if s
+// had no escapes
+func parseSGR(s string) (text string, styles []vaxis.Style) {
+	if !strings.Contains(s, "\x1b") {
+		return s, nil
+	}
+	var b strings.Builder
+	var style vaxis.Style
+	for s != "" {
+		if rest, ok := strings.CutPrefix(s, "\x1b"); ok {
+			style, s = skipEscape(style, rest)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		b.WriteRune(r)
+		styles = append(styles, style)
+		s = s[size:]
+	}
+	if styles == nil {
+		styles = []vaxis.Style{}
+	}
+	return b.String(), styles
+}
+
+// skipEscape skips an escape sequence, given what follows its ESC, returning the style it leaves and the
+// text after it. a sequence that's cut off is dropped up to where it's cut, so the rest of the line stays
+func skipEscape(style vaxis.Style, s string) (vaxis.Style, string) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		// CSI, parameters up to a final byte from @ to ~. only m sets the style, the rest like erase line go
+		s = s[1:]
+		end := strings.IndexFunc(s, func(r rune) bool { return r >= '@' && r <= '~' })
+		if end < 0 {
+			return style, s
+		}
+		if s[end] == 'm' {
+			style = applySGR(style, s[:end])
+		}
+		return style, s[end+1:]
+	case strings.HasPrefix(s, "]"):
+		// OSC, up to BEL or ST. "8;params;url" links the text that follows, until an empty url
+		s = s[1:]
+		end, termLen := strings.IndexByte(s, '\a'), 1
+		if st := strings.Index(s, "\x1b\\"); st >= 0 && (end < 0 || st < end) {
+			end, termLen = st, 2
+		}
+		if end < 0 {
+			return style, s
+		}
+		if link, ok := strings.CutPrefix(s[:end], "8;"); ok {
+			style.HyperlinkParams, style.Hyperlink, _ = strings.Cut(link, ";")
+		}
+		return style, s[end+termLen:]
+	case len(s) >= 2 && strings.ContainsRune("()*+", rune(s[0])):
+		// charset designation, like the ESC ( B in tput sgr0
+		return style, s[2:]
+	case s != "":
+		// two byte sequences like ESC =
+		_, size := utf8.DecodeRuneInString(s)
+		return style, s[size:]
+	}
+	return style, s
+}
+
+// applySGR applies the parameters of an SGR escape to style
+func applySGR(style vaxis.Style, params string) vaxis.Style {
+	ps := strings.Split(params, ";")
+	for i := 0; i < len(ps); i++ {
+		subs := strings.Split(ps[i], ":")
+		n, _ := strconv.Atoi(subs[0]) // empty is 0
+		switch {
+		case n == 0:
+			// links aren't SGR, they end with their own escape
+			style = vaxis.Style{Hyperlink: style.Hyperlink, HyperlinkParams: style.HyperlinkParams}
+		case n == 1:
+			style.Attribute |= vaxis.AttrBold
+		case n == 2:
+			style.Attribute |= vaxis.AttrDim
+		case n == 3:
+			style.Attribute |= vaxis.AttrItalic
+		case n == 4:
+			style.UnderlineStyle = vaxis.UnderlineSingle
+		case n == 7:
+			style.Attribute |= vaxis.AttrReverse
+		case n == 9:
+			style.Attribute |= vaxis.AttrStrikethrough
+		case n == 22:
+			style.Attribute &^= vaxis.AttrBold | vaxis.AttrDim
+		case n == 23:
+			style.Attribute &^= vaxis.AttrItalic
+		case n == 24:
+			style.UnderlineStyle = vaxis.UnderlineOff
+		case n == 27:
+			style.Attribute &^= vaxis.AttrReverse
+		case n == 29:
+			style.Attribute &^= vaxis.AttrStrikethrough
+		case n >= 30 && n <= 37:
+			style.Foreground = vaxis.IndexColor(uint8(n - 30))
+		case n >= 90 && n <= 97:
+			style.Foreground = vaxis.IndexColor(uint8(n - 90 + 8))
+		case n == 39:
+			style.Foreground = vaxis.ColorDefault
+		case n >= 40 && n <= 47:
+			style.Background = vaxis.IndexColor(uint8(n - 40))
+		case n >= 100 && n <= 107:
+			style.Background = vaxis.IndexColor(uint8(n - 100 + 8))
+		case n == 49:
+			style.Background = vaxis.ColorDefault
+		case n == 38 || n == 48:
+			// "38;5;n" takes the next parameters, "38:5:n" has them as subparameters
+			args := ps[i+1:]
+			if len(subs) > 1 {
+				args = subs[1:]
+				// "38:2:cs:r:g:b" may have a colour space before the rgb
+				if args[0] == "2" && len(args) == 5 {
+					args = append([]string{"2"}, args[2:]...)
+				}
+			}
+			c, used := sgrColour(args)
+			if len(subs) == 1 {
+				i += used
+			}
+			if n == 38 {
+				style.Foreground = c
+			} else {
+				style.Background = c
+			}
+		}
+	}
+	return style
+}
+
+// sgrColour reads an extended colour like "5", "n" or "2", "r", "g", "b", returning how many parameters it took
+func sgrColour(args []string) (vaxis.Color, int) {
+	num := func(s string) uint8 {
+		n, _ := strconv.Atoi(s)
+		return uint8(n)
+	}
+	switch {
+	case len(args) >= 2 && args[0] == "5":
+		return vaxis.IndexColor(num(args[1])), 2
+	case len(args) >= 4 && args[0] == "2":
+		return vaxis.RGBColor(num(args[1]), num(args[2]), num(args[3])), 4
+	}
+	return vaxis.ColorDefault, len(args)
+}
+
+// parseColour parses the payload of a colour marker, a terminal colour from 0 to 255
+func parseColour(s string) (vaxis.Color, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 255 {
+		return 0, fmt.Errorf("colour %q isn't from 0 to 255", s)
+	}
+	return vaxis.IndexColor(uint8(n)), nil
+}
+
+// listItem is a line from a list script, parsed for display
+type listItem struct {
+	text    string // what's shown and filtered
+	style   lineStyle
+	id      string        // follows the item across reloads, for the cursor, marks and history. the text unless the script says otherwise
+	arg     string        // what run, action and preview modes get for the item. the text unless the script says otherwise
+	preview *itemPreview  // shown instead of running preview mode, if set
+	sgr     []vaxis.Style // the style of each rune of text from its ANSI escapes, nil if it had none
+}
+
+// itemPreview is an item's own preview, parsed the first time it's shown since it may be an image
+type itemPreview struct {
+	raw string
+	pv  *preview
+}
+
+func (ip *itemPreview) get() *preview {
+	if ip.pv == nil {
+		pv, err := parsePreview([]byte(ip.raw))
+		if err != nil {
+			pv = &preview{err: err}
 		}
+		ip.pv = pv
 	}
-	return text, style
+	return ip.pv
+}
+
+// parseItems parses the lines a script listed according to its format
+func parseItems(format string, lines []string) []listItem {
+	items := make([]listItem, 0, len(lines))
+	for i, raw := range lines {
+		if format != formatJSONL {
+			text, style, id := parseLineStyle(raw)
+			text, sgr := parseSGR(text)
+			items = append(items, listItem{text: text, style: style, id: cmp.Or(id, text), arg: text, sgr: sgr})
+			continue
+		}
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		it, err := parseJSONItem(raw)
+		if err != nil {
+			// bad lines are shown, so the script can be fixed
+			text := fmt.Sprintf("✗ line %d: %v", i+1, err)
+			it = listItem{text: text, style: lineStyle{error: true}, id: text}
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+// jsonItem is a line listed by a script with format = "jsonl"
+type jsonItem struct {
+	Text    string          `json:"text"`
+	ID      string          `json:"id"`      // the item's id and arg, the whole line if unset
+	Columns []string        `json:"columns"` // tab separated as the text, if there's no text
+	Style   []string        `json:"style"`   // names of markers without a payload, like "highlight" and "dim"
+	Fg      *uint8          `json:"fg"`
+	Bg      *uint8          `json:"bg"`
+	Right   string          `json:"right"`
+	Preview *string         `json:"preview"`
+	Icon    string          `json:"icon"`
+	Data    json.RawMessage `json:"data"` // for the script, which gets the whole line back if there's no id
+}
+
+func parseJSONItem(raw string) (listItem, error) {
+	var ji jsonItem
+	if err := json.Unmarshal([]byte(raw), &ji); err != nil {
+		return listItem{}, err
+	}
+	it := listItem{text: ji.Text, arg: ji.ID}
+	if it.text == "" {
+		it.text = strings.Join(ji.Columns, "\t")
+	}
+	it.text, it.sgr = parseSGR(it.text)
+	if it.arg == "" {
+		it.arg = raw
+	}
+	it.id = it.arg
+	for _, kind := range ji.Style {
+		switch kind {
+		case markerHighlight:
+			it.style.highlight = true
+		case markerStay:
+			it.style.stay = true
+		case markerLabel:
+			it.style.label = true
+		case markerDim:
+			it.style.dim = true
+		case markerItalic:
+			it.style.italic = true
+		case markerStrikethrough:
+			it.style.strikethrough = true
+		default:
+			return listItem{}, fmt.Errorf("unknown style %q", kind)
+		}
+	}
+	if ji.Fg != nil {
+		it.style.fg = vaxis.IndexColor(*ji.Fg)
+	}
+	if ji.Bg != nil {
+		it.style.bg = vaxis.IndexColor(*ji.Bg)
+	}
+	it.style.right = ji.Right
+	it.style.icon = ji.Icon
+	if ji.Preview != nil {
+		it.preview = &itemPreview{raw: *ji.Preview}
+	}
+	return it, nil
 }
 
 type spinner struct {
@@ -915,7 +2797,55 @@ func padRight(s string, p string, width int) string {
 }
 
 type config struct {
-	Scripts []scriptConf `toml:"scripts"`
+	Include  []string               `toml:"include"` // globs of more config files, ~ is home and relative is to this file
+	Scripts  []scriptConf           `toml:"scripts"`
+	Keys     map[string]string      `toml:"keys"`
+	Output   bool                   `toml:"output"` // as if every script set output
+	Profiles map[string]profileConf `toml:"profiles"`
+
+	pos       map[string]string // where keys were set, see scanPositions
+	undecoded []string          // paths in pos of keys that match no field, like "scripts.0.stay-open"
+}
+
+// profileConf narrows the config for one use of it, picked with --profile or $CMENU_PROFILE
+type profileConf struct {
+	OnStart  []string          `toml:"on_start"` // scripts shown at start, rather than those with the on-start trigger
+	Prefixes []string          `toml:"prefixes"` // prefix triggers to keep, all if unset
+	Keys     map[string]string `toml:"keys"`     // layered over the config's keys
+	Output   bool              `toml:"output"`
+}
+
+// applyProfile applies prof to conf and the parsed triggers
+func applyProfile(conf *config, prof profileConf, triggersOnStart map[string]struct{}, triggersPrefix map[string][]string) error {
+	if prof.OnStart != nil {
+		clear(triggersOnStart)
+		for _, scriptName := range prof.OnStart {
+			if !slices.ContainsFunc(conf.Scripts, func(sc scriptConf) bool { return sc.Name == scriptName }) {
+				return fmt.Errorf("on_start: unknown script %q", scriptName)
+			}
+			triggersOnStart[scriptName] = struct{}{}
+		}
+	}
+	if prof.Prefixes != nil {
+		for _, prefix := range prof.Prefixes {
+			if _, ok := triggersPrefix[prefix]; !ok {
+				return fmt.Errorf("prefixes: no scripts for prefix %q", prefix)
+			}
+		}
+		maps.DeleteFunc(triggersPrefix, func(prefix string, _ []string) bool {
+			return !slices.Contains(prof.Prefixes, prefix)
+		})
+	}
+	if len(prof.Keys) > 0 {
+		keys := maps.Clone(conf.Keys)
+		if keys == nil {
+			keys = map[string]string{}
+		}
+		maps.Copy(keys, prof.Keys)
+		conf.Keys = keys
+	}
+	conf.Output = conf.Output || prof.Output
+	return nil
 }
 
 type scriptConf struct {
@@ -926,22 +2856,580 @@ type scriptConf struct {
 	Columns  []int    `toml:"columns"`
 	StayOpen bool     `toml:"stay_open"`
 	Preview  bool     `toml:"preview"`
+
+	FilterColumns []int  `toml:"filter_columns"`
+	Multi         string `toml:"multi"`
+	Output        bool   `toml:"output"` // print the picked line, or its run mode stdout, and quit
+	Sort          string `toml:"sort"`
+	Format        string `toml:"format"`
+
+	Actions []scriptAction `toml:"actions"`
+
+	// how long the script's lines stay cached to show at start while it runs again, like "24h". unset is no cache
+	CacheTTL time.Duration `toml:"cache_ttl"`
+
+	// durations like "10s" for each mode, "0" for none. unset is defaultTimeout
+	ListTimeout    *time.Duration `toml:"list_timeout"`
+	RunTimeout     *time.Duration `toml:"run_timeout"`
+	PreviewTimeout *time.Duration `toml:"preview_timeout"`
 }
 
+// scriptAction is an extra verb a script handles, run in action mode with CMENU_ACTION=name when key is pressed
+type scriptAction struct {
+	Key      string `toml:"key"`
+	Name     string `toml:"name"`
+	StayOpen bool   `toml:"stay_open"`
+}
+
+// parseConfig reads the config at path, then the files it includes in order, then config.d/*.toml
+// beside it. later files add scripts and profiles, and override keys
 func parseConfig(path string) (config, error) {
-	configFile, err := os.Open(path)
+	conf, err := decodeConfig(path, 0)
+	if err != nil {
+		return config{}, err
+	}
+
+	paths, err := configFiles(path, conf.Include)
+	if err != nil {
+		return config{}, err
+	}
+
+	// the file each profile came from, for duplicate errors. duplicate scripts are left to validateConfig
+	profileSources := map[string]string{}
+	for name := range conf.Profiles {
+		profileSources[name] = path
+	}
+
+	seen := map[string]struct{}{filepath.Clean(path): {}}
+	for _, incPath := range paths {
+		if _, ok := seen[filepath.Clean(incPath)]; ok {
+			continue
+		}
+		seen[filepath.Clean(incPath)] = struct{}{}
+
+		inc, err := decodeConfig(incPath, len(conf.Scripts))
+		if err != nil {
+			return config{}, fmt.Errorf("include %s: %w", incPath, err)
+		}
+		if len(inc.Include) > 0 {
+			return config{}, fmt.Errorf("include %s: include is only read from the main config", incPath)
+		}
+		conf.Scripts = append(conf.Scripts, inc.Scripts...)
+		maps.Copy(conf.pos, inc.pos)
+		conf.undecoded = append(conf.undecoded, inc.undecoded...)
+		for name, prof := range inc.Profiles {
+			if prev, ok := profileSources[name]; ok {
+				return config{}, fmt.Errorf("duplicate profile %q in %s, already in %s", name, incPath, prev)
+			}
+			profileSources[name] = incPath
+			if conf.Profiles == nil {
+				conf.Profiles = map[string]profileConf{}
+			}
+			conf.Profiles[name] = prof
+		}
+		if len(inc.Keys) > 0 {
+			if conf.Keys == nil {
+				conf.Keys = map[string]string{}
+			}
+			maps.Copy(conf.Keys, inc.Keys)
+		}
+		conf.Output = conf.Output || inc.Output
+	}
+
+	return conf, nil
+}
+
+// configFiles lists the files the config at path includes, in order, then its config.d/*.toml
+func configFiles(path string, include []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range include {
+		if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+			home, _ := os.UserHomeDir()
+			pattern = filepath.Join(home, rest)
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %w", pattern, err)
+		}
+		paths = append(paths, matches...) // sorted by Glob
+	}
+	dropins, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "config.d", "*.toml"))
+	paths = append(paths, dropins...)
+	return paths, nil
+}
+
+// how often the config files are checked for changes
+const configPollInterval = time.Second
+
+// watchConfig polls the files making up the config at path, and posts an eventConfig when any of them change
+func watchConfig(ctx context.Context, vx *vaxis.Vaxis, path string) {
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	stamp := configStamp(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s := configStamp(path); s != stamp {
+			stamp = s
+		} else {
+			continue
+		}
+
+		conf, err := parseConfig(path)
+		vx.PostEvent(eventConfig{conf, err})
+	}
+}
+
+// configStamp summarises the size and modification time of each file making up the config at path
+func configStamp(path string) string {
+	files := []string{path}
+	if conf, err := decodeConfig(path, 0); err == nil {
+		paths, _ := configFiles(path, conf.Include)
+		files = append(files, paths...)
+	}
+	var stamp strings.Builder
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&stamp, "%s %d %d\n", file, fi.Size(), fi.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&stamp, "%s -\n", file)
+		}
+	}
+	return stamp.String()
+}
+
+// decodeConfig reads one config file. its scripts are numbered from offset in conf.pos
+func decodeConfig(path string, offset int) (config, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return config{}, err
 	}
 
 	var conf config
-	if _, err := toml.NewDecoder(configFile).Decode(&conf); err != nil {
+	md, err := toml.Decode(string(data), &conf)
+	if err != nil {
 		return config{}, err
 	}
+	conf.pos = map[string]string{}
+	scanPositions(path, data, offset, conf.pos)
+
+	// the decoder's keys have no array indexes, so find them in pos. a table with no fields only reports the table
+	undecoded := map[string]bool{} // to whether it was found
+	for _, key := range md.Undecoded() {
+		undecoded[strings.Join(key, ".")] = false
+	}
+	for _, p := range slices.Sorted(maps.Keys(conf.pos)) {
+		key := unindexed(p)
+		if _, ok := undecoded[key]; !ok {
+			continue
+		}
+		undecoded[key] = true
+		if slices.ContainsFunc(conf.undecoded, func(parent string) bool { return strings.HasPrefix(p, parent+".") }) {
+			continue
+		}
+		conf.undecoded = append(conf.undecoded, p)
+	}
+	slices.SortStableFunc(conf.undecoded, func(a, b string) int {
+		lineA, _ := strconv.Atoi(conf.pos[a][strings.LastIndex(conf.pos[a], ":")+1:])
+		lineB, _ := strconv.Atoi(conf.pos[b][strings.LastIndex(conf.pos[b], ":")+1:])
+		return cmp.Compare(lineA, lineB)
+	})
+	// keys of inline tables aren't scanned, so those can only point at the file
+	for _, key := range slices.Sorted(maps.Keys(undecoded)) {
+		if undecoded[key] {
+			continue
+		}
+		if slices.ContainsFunc(slices.Collect(maps.Keys(undecoded)), func(parent string) bool { return strings.HasPrefix(key, parent+".") }) {
+			continue
+		}
+		conf.undecoded = append(conf.undecoded, key)
+		conf.pos[key] = path
+	}
 
 	return conf, nil
 }
 
+// unindexed drops the array indexes from a path in config.pos, so "scripts.0.actions.1.key" is "scripts.actions.key"
+func unindexed(path string) string {
+	parts := strings.Split(path, ".")
+	return strings.Join(slices.DeleteFunc(parts, isIndex), ".")
+}
+
+func isIndex(part string) bool {
+	_, err := strconv.Atoi(part)
+	return err == nil
+}
+
+// scanPositions records where each table and key of a toml file is, by dotted paths like "scripts.0.name"
+// or "keys.Ctrl+j". the decoder doesn't expose positions, and a line scan is enough to point at a mistake.
+// scripts are numbered from offset, their index once merged with other files
+func scanPositions(file string, data []byte, offset int, pos map[string]string) {
+	var prefix, scriptPrefix string
+	var numScripts int
+	counts := map[string]int{} // of other arrays of tables
+	for i, ln := range strings.Split(string(data), "\n") {
+		ln = strings.TrimSpace(ln)
+		at := fmt.Sprintf("%s:%d", file, i+1)
+		switch {
+		case ln == "" || strings.HasPrefix(ln, "#"):
+		case strings.HasPrefix(ln, "[["):
+			name, _, _ := strings.Cut(ln[2:], "]]")
+			name = strings.ReplaceAll(strings.TrimSpace(name), `"`, "")
+			switch sub, ok := strings.CutPrefix(name, "scripts."); {
+			case name == "scripts":
+				prefix = fmt.Sprintf("scripts.%d", offset+numScripts)
+				scriptPrefix = prefix
+				numScripts++
+			case ok && scriptPrefix != "":
+				arr := scriptPrefix + "." + sub
+				prefix = fmt.Sprintf("%s.%d", arr, counts[arr])
+				counts[arr]++
+			default:
+				prefix = fmt.Sprintf("%s.%d", name, counts[name])
+				counts[name]++
+			}
+			pos[prefix] = at
+		case strings.HasPrefix(ln, "["):
+			name, _, _ := strings.Cut(ln[1:], "]")
+			prefix = strings.ReplaceAll(strings.TrimSpace(name), `"`, "")
+			scriptPrefix = ""
+			pos[prefix] = at
+		default:
+			key, _, ok := strings.Cut(ln, "=")
+			if !ok {
+				continue // inside a multi-line value
+			}
+			key = strings.Trim(strings.TrimSpace(key), `"'`)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			pos[key] = at
+		}
+	}
+}
+
+// at is where the key at path was set, or failing that its nearest parent, for error messages
+func (c config) at(path string) string {
+	for {
+		if p, ok := c.pos[path]; ok {
+			return p
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return "config"
+		}
+		path = path[:i]
+	}
+}
+
+// scriptConfigError is a problem validateConfig found that's confined to one script's config
+type scriptConfigError struct {
+	script string
+	err    error
+}
+
+func (e *scriptConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scriptConfigError) Unwrap() error {
+	return e.err
+}
+
+// validateConfig reports every problem with conf, each prefixed with the file and line it's at. problems
+// confined to a script are *scriptConfigError
+func validateConfig(conf config) []error {
+	var problems []error
+	problemf := func(path string, format string, a ...any) {
+		problems = append(problems, fmt.Errorf("%s: %s", conf.at(path), fmt.Sprintf(format, a...)))
+	}
+
+	names := map[string]int{}
+	prefixes := map[string]struct{}{}
+	for i, sconf := range conf.Scripts {
+		if sconf.Name == "" {
+			continue
+		}
+		if prev, ok := names[sconf.Name]; ok {
+			problemf(fmt.Sprintf("scripts.%d.name", i), "duplicate script %q, already at %s", sconf.Name, conf.at(fmt.Sprintf("scripts.%d.name", prev)))
+			continue
+		}
+		names[sconf.Name] = i
+		for _, trigger := range sconf.Triggers {
+			if typ, value, _ := strings.Cut(trigger, " "); typ == "pre" && value != "" {
+				prefixes[value] = struct{}{}
+			}
+		}
+	}
+
+	for i, sconf := range conf.Scripts {
+		path := fmt.Sprintf("scripts.%d", i)
+		if sconf.Name == "" {
+			problemf(path, "script has no name")
+			continue
+		}
+		scriptf := func(key string, format string, a ...any) {
+			problems = append(problems, &scriptConfigError{
+				script: sconf.Name,
+				err:    fmt.Errorf("%s: script %q: %s", conf.at(path+"."+key), sconf.Name, fmt.Sprintf(format, a...)),
+			})
+		}
+		// triggers decide where scripts show up, so problems with them are the whole config's
+		triggerf := func(format string, a ...any) {
+			problemf(path+".triggers", "script %q: %s", sconf.Name, fmt.Sprintf(format, a...))
+		}
+
+		for _, key := range conf.undecoded {
+			if rest, ok := strings.CutPrefix(key, path+"."); ok {
+				scriptf(rest, "unknown key %q", unindexed(rest))
+			}
+		}
+
+		if sconf.Path == "" {
+			scriptf("path", "no path")
+		} else if _, err := exec.LookPath(sconf.Path); err != nil {
+			scriptf("path", "%v", err)
+		}
+		if sconf.Colour < 0 || sconf.Colour > 255 {
+			scriptf("colour", "colour %d isn't from 0 to 255", sconf.Colour)
+		}
+		for _, c := range sconf.Columns {
+			if c < 1 {
+				scriptf("columns", "column %d, columns start at 1", c)
+			}
+		}
+		for _, c := range sconf.FilterColumns {
+			if c < 1 {
+				scriptf("filter_columns", "column %d, columns start at 1", c)
+			}
+		}
+		switch sconf.Multi {
+		case "", multiEach, multiBatch:
+		default:
+			scriptf("multi", "unknown multi mode %q", sconf.Multi)
+		}
+		switch sconf.Format {
+		case "", formatJSONL:
+		default:
+			scriptf("format", "unknown format %q", sconf.Format)
+		}
+		switch sconf.Sort {
+		case "", sortFrecency:
+		default:
+			scriptf("sort", "unknown sort %q", sconf.Sort)
+		}
+		if sconf.CacheTTL < 0 {
+			scriptf("cache_ttl", "negative cache_ttl %v", sconf.CacheTTL)
+		}
+		for _, t := range []struct {
+			key string
+			d   *time.Duration
+		}{{"list_timeout", sconf.ListTimeout}, {"run_timeout", sconf.RunTimeout}, {"preview_timeout", sconf.PreviewTimeout}} {
+			if t.d != nil && *t.d < 0 {
+				scriptf(t.key, "negative %s %v", t.key, *t.d)
+			}
+		}
+
+		actionKeys := map[string]struct{}{}
+		for j, act := range sconf.Actions {
+			key := fmt.Sprintf("actions.%d", j)
+			if act.Key == "" || act.Name == "" {
+				scriptf(key, "action needs a key and a name")
+				continue
+			}
+			name, err := normaliseKey(act.Key)
+			if err != nil {
+				scriptf(key, "%v", err)
+				continue
+			}
+			if _, ok := actionKeys[name]; ok {
+				scriptf(key, "duplicate action key %q", act.Key)
+			}
+			actionKeys[name] = struct{}{}
+		}
+
+		for _, trigger := range sconf.Triggers {
+			switch typ, value, _ := strings.Cut(trigger, " "); typ {
+			case "on-start":
+			case "pre":
+				if value == "" {
+					triggerf("pre trigger needs a prefix")
+				}
+			case "script":
+				if _, ok := names[value]; !ok {
+					triggerf("script trigger for unknown script %q", value)
+				}
+			case "interval":
+				if d, err := time.ParseDuration(value); err != nil {
+					triggerf("parse duration: %v", err)
+				} else if d <= 0 {
+					triggerf("interval %v isn't positive", d)
+				}
+			default:
+				triggerf("unknown trigger type %q", typ)
+			}
+		}
+	}
+
+	for _, key := range conf.undecoded {
+		if rest, ok := strings.CutPrefix(key, "scripts."); ok {
+			if i, _, _ := strings.Cut(rest, "."); isIndex(i) {
+				continue // the script's own, above
+			}
+		}
+		problemf(key, "unknown key %q", unindexed(key))
+	}
+
+	checkKeys := func(path string, keys map[string]string) {
+		for _, key := range slices.Sorted(maps.Keys(keys)) {
+			if _, err := normaliseKey(key); err != nil {
+				problemf(path+"."+key, "%v", err)
+			}
+			if action := keys[key]; action != actionNone && !slices.Contains(keyActions, action) {
+				problemf(path+"."+key, "unknown action %q for key %q", action, key)
+			}
+		}
+	}
+	checkKeys("keys", conf.Keys)
+
+	for _, name := range slices.Sorted(maps.Keys(conf.Profiles)) {
+		prof := conf.Profiles[name]
+		path := "profiles." + name
+		for _, scriptName := range prof.OnStart {
+			if _, ok := names[scriptName]; !ok {
+				problemf(path+".on_start", "profile %q: unknown script %q", name, scriptName)
+			}
+		}
+		for _, prefix := range prof.Prefixes {
+			if _, ok := prefixes[prefix]; !ok {
+				problemf(path+".prefixes", "profile %q: no scripts for prefix %q", name, prefix)
+			}
+		}
+		checkKeys(path+".keys", prof.Keys)
+	}
+
+	return problems
+}
+
+// actions that keys can be bound to in the [keys] table of the config
+const (
+	actionUp            = "up"
+	actionDown          = "down"
+	actionGroupNext     = "group-next"
+	actionGroupPrev     = "group-prev"
+	actionFirst         = "first"
+	actionLast          = "last"
+	actionPageUp        = "page-up"
+	actionPageDown      = "page-down"
+	actionMark          = "mark"
+	actionMarkDown      = "mark-down"
+	actionMarkUp        = "mark-up"
+	actionReload        = "reload"
+	actionRun           = "run"
+	actionRunStay       = "run-stay"
+	actionTogglePreview = "toggle-preview"
+	actionToggleLog     = "toggle-log"
+	actionForget        = "forget"
+	actionQuit          = "quit"
+
+	actionNone = "none" // unbinds a default key
+)
+
+var keyActions = []string{
+	actionUp, actionDown, actionGroupNext, actionGroupPrev, actionFirst, actionLast, actionPageUp, actionPageDown,
+	actionMark, actionMarkDown, actionMarkUp, actionReload, actionRun, actionRunStay, actionTogglePreview, actionToggleLog, actionForget,
+	actionQuit,
+}
+
+// keyModifiers are the modifiers in the order vaxis.Key.String writes them
+var keyModifiers = []string{"Meta", "Hyper", "Super", "Ctrl", "Alt", "Shift"}
+
+// normaliseKey puts a key name like "shift+ctrl+Page_Up" in one form, so names from the config match
+// vaxis.Key.String whatever their case or modifier order. single characters keep their case, except with
+// ctrl, which vaxis reports in lowercase
+func normaliseKey(name string) (string, error) {
+	parts := strings.Split(name, "+")
+	key, mods := parts[len(parts)-1], parts[:len(parts)-1]
+	if key == "" && len(mods) > 0 && mods[len(mods)-1] == "" {
+		key, mods = "+", mods[:len(mods)-1] // like "Ctrl++"
+	}
+	if key == "" {
+		return "", fmt.Errorf("key %q has no key", name)
+	}
+	has := make([]bool, len(keyModifiers))
+	for _, mod := range mods {
+		i := slices.IndexFunc(keyModifiers, func(m string) bool { return strings.EqualFold(m, mod) })
+		if i < 0 {
+			return "", fmt.Errorf("key %q has unknown modifier %q", name, mod)
+		}
+		has[i] = true
+	}
+	var b strings.Builder
+	for i, mod := range keyModifiers {
+		if has[i] {
+			b.WriteString(strings.ToLower(mod) + "+")
+		}
+	}
+	if utf8.RuneCountInString(key) > 1 || has[slices.Index(keyModifiers, "Ctrl")] {
+		key = strings.ToLower(key)
+	}
+	b.WriteString(key)
+	return b.String(), nil
+}
+
+// defaultKeys maps key names, as vaxis.Key.String formats them, to actions
+var defaultKeys = map[string]string{
+	"Escape":      actionQuit,
+	"Ctrl+c":      actionQuit,
+	"Down":        actionDown,
+	"Up":          actionUp,
+	"Shift+Down":  actionGroupNext,
+	"Shift+Up":    actionGroupPrev,
+	"Tab":         actionMarkDown,
+	"Shift+Tab":   actionMarkUp,
+	"Home":        actionFirst,
+	"End":         actionLast,
+	"Page_Down":   actionPageDown,
+	"Page_Up":     actionPageUp,
+	"Ctrl+r":      actionReload,
+	"Ctrl+p":      actionTogglePreview,
+	"Ctrl+l":      actionToggleLog,
+	"Ctrl+x":      actionForget,
+	"Enter":       actionRun,
+	"Shift+Enter": actionRunStay,
+}
+
+// parseKeys layers the configured bindings over defaultKeys, keyed by normaliseKey
+func parseKeys(conf map[string]string) (map[string]string, error) {
+	keys := map[string]string{}
+	for key, action := range defaultKeys {
+		key, _ = normaliseKey(key)
+		keys[key] = action
+	}
+	for key, action := range conf {
+		key, err := normaliseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case action == actionNone:
+			delete(keys, key)
+		case slices.Contains(keyActions, action):
+			keys[key] = action
+		default:
+			return nil, fmt.Errorf("unknown action %q for key %q", action, key)
+		}
+	}
+	return keys, nil
+}
+
 // taskSlot runs at most one task at a time. take starts a new task: if a task with the
 // same key is already running, it returns ok=false. if a task with a different key is
 // running, that task's context is cancelled
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~rockorager/vaxis"
+)
+
+func TestParseTerm(t *testing.T) {
+	tests := []struct {
+		tok  string
+		want term
+		ok   bool
+	}{
+		{"foo", term{kind: termFuzzy, text: []rune("foo")}, true},
+		{"Foo", term{kind: termFuzzy, text: []rune("foo")}, true},
+		{"'foo", term{kind: termExact, text: []rune("foo")}, true},
+		{"'foo$", term{kind: termExact, text: []rune("foo$")}, true},
+		{"^foo", term{kind: termPrefix, text: []rune("foo")}, true},
+		{"foo$", term{kind: termSuffix, text: []rune("foo")}, true},
+		{"^foo$", term{kind: termEqual, text: []rune("foo")}, true},
+		{"!foo", term{kind: termExact, inverse: true, text: []rune("foo")}, true},
+		{"!'foo", term{kind: termExact, inverse: true, text: []rune("foo")}, true},
+		{"!^foo", term{kind: termPrefix, inverse: true, text: []rune("foo")}, true},
+		{"!.mp3$", term{kind: termSuffix, inverse: true, text: []rune(".mp3")}, true},
+		{"!^foo$", term{kind: termEqual, inverse: true, text: []rune("foo")}, true},
+		{"@2:foo", term{kind: termFuzzy, column: 2, text: []rune("foo")}, true},
+		{"@2:!foo$", term{kind: termSuffix, inverse: true, column: 2, text: []rune("foo")}, true},
+		{"@x:foo", term{kind: termFuzzy, text: []rune("@x:foo")}, true},
+		{"!", term{}, false},
+		{"^", term{}, false},
+		{"$", term{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTerm(tt.tok)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTerm(%q) = %+v, %v, want %+v, %v", tt.tok, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		s    string
+		want [][]string // the text of each term, by group
+	}{
+		{"", nil},
+		{"foo bar", [][]string{{"foo"}, {"bar"}}},
+		{"a | b c", [][]string{{"a", "b"}, {"c"}}},
+		{"| a", [][]string{{"a"}}},
+		{"a | | b", [][]string{{"a", "b"}}},
+		{"a ! b", [][]string{{"a"}, {"b"}}},
+	}
+	for _, tt := range tests {
+		var got [][]string
+		for _, group := range parseQuery(tt.s) {
+			var texts []string
+			for _, t := range group {
+				texts = append(texts, string(t.text))
+			}
+			got = append(got, texts)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQuery(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	tests := []struct {
+		query   string
+		text    string
+		columns []int
+		want    bool
+	}{
+		{"fb", "foo bar", nil, true},
+		{"bf", "foo bar", nil, false},
+		{"'oo b", "foo bar", nil, true},
+		{"'ob", "foo bar", nil, false},
+		{"^foo", "foo bar", nil, true},
+		{"^bar", "foo bar", nil, false},
+		{"bar$", "foo bar", nil, true},
+		{"foo$", "foo bar", nil, false},
+		{"^foo bar$", "foo bar", nil, true},
+		{"^foo$", "foo", nil, true},
+		{"^foo$", "foo bar", nil, false},
+		{"!baz", "foo bar", nil, true},
+		{"!bar", "foo bar", nil, false},
+		{"!'bar", "foo bar", nil, false},
+		{"!fb", "foo bar", nil, true}, // exact, so not a fuzzy match
+		{"!^foo", "foo bar", nil, false},
+		{"!^bar", "foo bar", nil, true},
+		{"!.mp3$", "a.mp3", nil, false},
+		{"!.mp3$", "a.mp3.txt", nil, true},
+		{"!^a.mp3$", "a.mp3", nil, false},
+		{"!^a$", "a.mp3", nil, true},
+		{"baz | bar", "foo bar", nil, true},
+		{"baz | qux", "foo bar", nil, false},
+		{"foo !bar", "foo bar", nil, false},
+		{"FOO", "foo bar", nil, true},
+		{"id", "name\tid", []int{1}, false},
+		{"@2:id", "name\tid", []int{1}, true},
+		{"@2:^id$", "name\tid", nil, true},
+		{"@2:!id", "name\tid", nil, false},
+	}
+	for _, tt := range tests {
+		_, _, ok := parseQuery(tt.query).match(tt.text, tt.columns)
+		if ok != tt.want {
+			t.Errorf("query %q match %q = %v, want %v", tt.query, tt.text, ok, tt.want)
+		}
+	}
+}
+
+func TestQueryMatchPositions(t *testing.T) {
+	tests := []struct {
+		query   string
+		text    string
+		columns []int
+		want    []int
+	}{
+		{"fb", "foo bar", nil, []int{0, 4}},
+		{"'oo", "foo bar", nil, []int{1, 2}},
+		{"^fo bar$", "foo bar", nil, []int{0, 1, 4, 5, 6}},
+		{"!baz", "foo bar", nil, nil},
+		{"@2:i", "name\tid", nil, []int{5}},
+	}
+	for _, tt := range tests {
+		_, got, _ := parseQuery(tt.query).match(tt.text, tt.columns)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("query %q match %q positions = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestQueryMatchRanking(t *testing.T) {
+	tests := []struct {
+		query  string
+		better string
+		worse  string
+	}{
+		{"gtcm", "git commit", "get some thing commit more"},
+		{"fb", "foo bar", "foobar"},    // word boundaries over mid-word
+		{"fb", "fooBar", "foobar"},     // camel case is a boundary
+		{"fb", "foo/bar", "xfoo xbar"}, // so is a path separator
+		{"bar", "foo bar", "foobar"},
+		{"abc", "abcxx", "axbxc"},        // consecutive over scattered
+		{"ac", "abc", "abbbbbc"},         // shorter gaps
+		{"cm", "cmenu", "xcmenu"},        // the first char counts most
+		{"fo ba", "foo bar", "foo xbar"}, // terms' scores add up
+	}
+	for _, tt := range tests {
+		q := parseQuery(tt.query)
+		better, _, ok := q.match(tt.better, nil)
+		if !ok {
+			t.Errorf("query %q doesn't match %q", tt.query, tt.better)
+			continue
+		}
+		worse, _, ok := q.match(tt.worse, nil)
+		if !ok {
+			t.Errorf("query %q doesn't match %q", tt.query, tt.worse)
+			continue
+		}
+		if better <= worse {
+			t.Errorf("query %q scores %q %d, not above %q %d", tt.query, tt.better, better, tt.worse, worse)
+		}
+	}
+}
+
+func TestExecScriptBackground(t *testing.T) {
+	// a launcher entry starts an app in the background and exits, leaving the app with its stdout and stderr
+	path := filepath.Join(t.TempDir(), "launch")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho starting >&2\necho \"$1\"\nsleep 1 &\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []bool{false, true} {
+		sc := &script{scriptConf: scriptConf{Name: "launch", Path: path}}
+		start := time.Now()
+		stdout, err := execScript(context.Background(), nil, nil, sc, "", "", []string{"app"}, output)
+		took := time.Since(start)
+		if err != nil {
+			t.Fatalf("output %v: exec: %v", output, err)
+		}
+		// without output nothing waits on the background process. with it, stdout is a pipe that it holds
+		// until WaitDelay
+		if !output && took >= 100*time.Millisecond {
+			t.Errorf("output %v: exec took %v, waited on the background process", output, took)
+		}
+		if want := map[bool]string{false: "", true: "app\n"}[output]; string(stdout) != want {
+			t.Errorf("output %v: stdout = %q, want %q", output, stdout, want)
+		}
+		if len(sc.logs) != 1 || sc.logs[0].stderr != "starting\n" {
+			t.Errorf("output %v: logs = %+v, want the stderr of one invocation", output, sc.logs)
+		}
+	}
+}
+
+func TestWrapLine(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"", 4, []string{""}},
+		{"abc", 4, []string{"abc"}},
+		{"abcdefgh", 4, []string{"abcd", "efgh"}},
+		{"abcdefghi", 4, []string{"abcd", "efgh", "i"}},
+		{"a🦊b🦊", 3, []string{"a🦊", "b🦊"}},
+		{"🦊🦊", 1, []string{"🦊", "🦊"}},
+	}
+	for _, tt := range tests {
+		if got := wrapLine(&vaxis.Vaxis{}, tt.text, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapLine(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestNormaliseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"Ctrl+j", "ctrl+j", true},
+		{"ctrl+j", "ctrl+j", true},
+		{"CTRL+J", "ctrl+j", true},
+		{"Shift+Ctrl+Up", "ctrl+shift+up", true},
+		{"page_down", "page_down", true},
+		{"Alt+J", "alt+J", true},
+		{"A", "A", true},
+		{"Ctrl++", "ctrl++", true},
+		{"+", "+", true},
+		{"Cmd+j", "", false},
+		{"Ctrl+", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normaliseKey(tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("normaliseKey(%q) = %q, %v, want %q, ok %v", tt.name, got, err, tt.want, tt.ok)
+		}
+	}
+
+	// names from the config match what vaxis reports for the key
+	for _, key := range []vaxis.Key{
+		{Keycode: 'j', Modifiers: vaxis.ModCtrl},
+		{Keycode: vaxis.KeyUp, Modifiers: vaxis.ModShift | vaxis.ModCtrl},
+		{Keycode: vaxis.KeyPgDown},
+	} {
+		if _, err := normaliseKey(key.String()); err != nil {
+			t.Errorf("normaliseKey(%q): %v", key.String(), err)
+		}
+	}
+	keys, err := parseKeys(map[string]string{"ctrl+J": actionDown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := normaliseKey(vaxis.Key{Keycode: 'j', Modifiers: vaxis.ModCtrl}.String())
+	if keys[name] != actionDown {
+		t.Errorf("ctrl+J isn't bound to Ctrl+j")
+	}
+	if _, err := parseKeys(map[string]string{"Cmd+j": actionDown}); err == nil {
+		t.Errorf("parseKeys accepted an unknown modifier")
+	}
+}
+
+func TestValidateConfigScriptProblems(t *testing.T) {
+	conf := config{
+		Scripts: []scriptConf{
+			{Name: "ok", Path: "sh"},
+			{Name: "missing", Path: "cmenu-no-such-script", Colour: 300},
+			{Name: "trigger", Path: "sh", Triggers: []string{"sometimes"}},
+		},
+		Keys: map[string]string{"Cmd+j": actionDown},
+	}
+	scripts := map[string]int{}
+	var other int
+	for _, problem := range validateConfig(conf) {
+		if serr := (*scriptConfigError)(nil); errors.As(problem, &serr) {
+			scripts[serr.script]++
+		} else {
+			other++
+		}
+	}
+	// the missing path and the colour are the script's own, the trigger and key are the config's
+	if want := map[string]int{"missing": 2}; !reflect.DeepEqual(scripts, want) {
+		t.Errorf("script problems = %v, want %v", scripts, want)
+	}
+	if other != 2 {
+		t.Errorf("got %d other problems, want 2", other)
+	}
+}
+
+// writeConfig writes files into a temporary directory, and returns the path of its config.toml
+func writeConfig(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "config.toml")
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		scripts []string
+		keys    map[string]string
+		output  bool
+		err     string
+	}{
+		{
+			name: "include order",
+			files: map[string]string{
+				"config.toml": `include = ["b.toml", "a/*.toml", "missing/*.toml"]
+[[scripts]]
+name = "main"
+`,
+				"b.toml":            "[[scripts]]\nname = \"b\"\n",
+				"a/2.toml":          "[[scripts]]\nname = \"a2\"\n",
+				"a/1.toml":          "[[scripts]]\nname = \"a1\"\n",
+				"config.d/z.toml":   "[[scripts]]\nname = \"dz\"\n",
+				"config.d/y.toml":   "[[scripts]]\nname = \"dy\"\n",
+				"config.d/notes.md": "not config",
+			},
+			scripts: []string{"main", "b", "a1", "a2", "dy", "dz"},
+		},
+		{
+			name: "files are read once",
+			files: map[string]string{
+				"config.toml":     `include = ["config.toml", "config.d/x.toml"]`,
+				"config.d/x.toml": "[[scripts]]\nname = \"x\"\n",
+			},
+			scripts: []string{"x"},
+		},
+		{
+			name: "later keys win",
+			files: map[string]string{
+				"config.toml":     "include = [\"inc.toml\"]\n[keys]\n\"Ctrl+j\" = \"down\"\n\"Ctrl+k\" = \"up\"\n",
+				"inc.toml":        "[keys]\n\"Ctrl+k\" = \"none\"\n",
+				"config.d/x.toml": "output = true\n[keys]\n\"Ctrl+j\" = \"up\"\n",
+			},
+			keys:   map[string]string{"Ctrl+j": "up", "Ctrl+k": "none"},
+			output: true,
+		},
+		{
+			name: "duplicate profile",
+			files: map[string]string{
+				"config.toml":     "[profiles.p]\noutput = true\n",
+				"config.d/x.toml": "[profiles.p]\n",
+			},
+			err: `duplicate profile "p" in config.d/x.toml, already in config.toml`,
+		},
+		{
+			name: "nested include",
+			files: map[string]string{
+				"config.toml": `include = ["inc.toml"]`,
+				"inc.toml":    `include = ["other.toml"]`,
+			},
+			err: "include inc.toml: include is only read from the main config",
+		},
+		{
+			name: "bad include",
+			files: map[string]string{
+				"config.toml": `include = ["inc.toml"]`,
+				"inc.toml":    "[[scripts]\n",
+			},
+			err: "include inc.toml: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.files)
+			conf, err := parseConfig(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(strings.ReplaceAll(err.Error(), filepath.Dir(path)+"/", ""), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var scripts []string
+			for _, sconf := range conf.Scripts {
+				scripts = append(scripts, sconf.Name)
+			}
+			if !reflect.DeepEqual(scripts, tt.scripts) {
+				t.Errorf("scripts = %q, want %q", scripts, tt.scripts)
+			}
+			if !reflect.DeepEqual(conf.Keys, tt.keys) {
+				t.Errorf("keys = %v, want %v", conf.Keys, tt.keys)
+			}
+			if conf.Output != tt.output {
+				t.Errorf("output = %v, want %v", conf.Output, tt.output)
+			}
+		})
+	}
+}
+
+func TestConfigPositions(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"config.toml": `include = ["inc/*.toml"]
+
+[keys]
+"Ctrl+j" = "down"
+
+# the first script
+[[scripts]]
+name = "x"
+path = "sh"
+
+[[scripts]]
+name = "y"
+path = "sh"
+  [[scripts.actions]]
+  key = "Ctrl+o"
+  name = "open"
+`,
+		"inc/x.toml": `[[scripts]]
+name = "x"
+path = "sh"
+`,
+		"config.d/z.toml": `
+[[scripts]]
+name = "z"
+triggers = ["sometimes"]
+`,
+	})
+	conf, err := parseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := func(s string) string {
+		return strings.ReplaceAll(s, filepath.Dir(path)+"/", "")
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"include", "config.toml:1"},
+		{"keys.Ctrl+j", "config.toml:4"},
+		{"scripts.0", "config.toml:7"},
+		{"scripts.0.name", "config.toml:8"},
+		{"scripts.1.actions.0.name", "config.toml:16"},
+		{"scripts.1.actions.0.run", "config.toml:14"},
+		{"scripts.2.path", "inc/x.toml:3"},
+		{"scripts.3.triggers", "config.d/z.toml:4"},
+		{"scripts.3.path", "config.d/z.toml:2"},
+		{"profiles.p", "config"},
+	}
+	for _, tt := range tests {
+		if got := rel(conf.at(tt.path)); got != tt.want {
+			t.Errorf("at(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	var problems []string
+	for _, problem := range validateConfig(conf) {
+		problems = append(problems, rel(problem.Error()))
+	}
+	want := []string{
+		`inc/x.toml:2: duplicate script "x", already at config.toml:8`,
+		`config.d/z.toml:2: script "z": no path`,
+		`config.d/z.toml:4: script "z": unknown trigger type "sometimes"`,
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems = %q\nwant %q", problems, want)
+	}
+}
+
+func TestValidateConfigUnknownKeys(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"config.toml": `include = ["inc/*.toml"]
+colour = 3
+
+[[scripts]]
+name = "a"
+path = "sh"
+stay-open = true
+filter-columns = [1]
+
+[[scripts.actions]]
+key = "Ctrl+x"
+name = "x"
+cmd = "x"
+
+[profiles.p]
+outpt = true
+
+[bogus]
+x = 1
+`,
+		"inc/x.toml": `[[scripts]]
+name = "b"
+path = "sh"
+trigers = ["on-start"]
+`,
+	})
+	conf, err := parseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, problem := range validateConfig(conf) {
+		got = append(got, strings.TrimPrefix(problem.Error(), filepath.Dir(path)+"/"))
+	}
+	want := []string{
+		`config.toml:7: script "a": unknown key "stay-open"`,
+		`config.toml:8: script "a": unknown key "filter-columns"`,
+		`config.toml:13: script "a": unknown key "actions.cmd"`,
+		`inc/x.toml:4: script "b": unknown key "trigers"`,
+		`config.toml:2: unknown key "colour"`,
+		`config.toml:16: unknown key "profiles.p.outpt"`,
+		`config.toml:18: unknown key "bogus"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems = %q\nwant %q", got, want)
+	}
+}
+
+func TestParseSGR(t *testing.T) {
+	blue := vaxis.Style{Foreground: vaxis.IndexColor(4), Attribute: vaxis.AttrBold}
+	link := vaxis.Style{Hyperlink: "https://example.com", HyperlinkParams: "id=1"}
+	tests := []struct {
+		s      string
+		text   string
+		styles []vaxis.Style
+	}{
+		{"plain", "plain", nil},
+		{"\x1b[01;34mdir\x1b[0m/", "dir/", []vaxis.Style{blue, blue, blue, {}}},
+		{"\x1b[1;34ma\x1b[mb", "ab", []vaxis.Style{blue, {}}},
+		{"\x1b(B\x1b[mhello", "hello", []vaxis.Style{{}, {}, {}, {}, {}}},
+		{"\x1b[01;31m\x1b[Kx\x1b[m\x1b[K", "x", []vaxis.Style{{Foreground: vaxis.IndexColor(1), Attribute: vaxis.AttrBold}}},
+		{"\x1b]8;id=1;https://example.com\x07a\x1b]8;;\x07b", "ab", []vaxis.Style{link, {}}},
+		{"\x1b]8;id=1;https://example.com\x1b\\a\x1b]8;;\x1b\\b", "ab", []vaxis.Style{link, {}}},
+		{"a\x1b[1", "a1", []vaxis.Style{{}, {}}},
+		{"a\x1b]8;;cut off", "a8;;cut off", []vaxis.Style{{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}}},
+		{"a\x1b", "a", []vaxis.Style{{}}},
+		{"\x1b=a", "a", []vaxis.Style{{}}},
+		{"\x1b[m", "", []vaxis.Style{}},
+	}
+	for _, tt := range tests {
+		text, styles := parseSGR(tt.s)
+		if text != tt.text || !reflect.DeepEqual(styles, tt.styles) {
+			t.Errorf("parseSGR(%q) = %q, %v, want %q, %v", tt.s, text, styles, tt.text, tt.styles)
+		}
+	}
+}
+
+func TestApplySGR(t *testing.T) {
+	bold := vaxis.Style{Attribute: vaxis.AttrBold}
+	tests := []struct {
+		from   vaxis.Style
+		params string
+		want   vaxis.Style
+	}{
+		{bold, "", vaxis.Style{}},
+		{bold, "0", vaxis.Style{}},
+		{vaxis.Style{}, "1;2;3;4;7;9", vaxis.Style{Attribute: vaxis.AttrBold | vaxis.AttrDim | vaxis.AttrItalic | vaxis.AttrReverse | vaxis.AttrStrikethrough, UnderlineStyle: vaxis.UnderlineSingle}},
+		{vaxis.Style{Attribute: vaxis.AttrBold | vaxis.AttrDim | vaxis.AttrItalic}, "22", vaxis.Style{Attribute: vaxis.AttrItalic}},
+		{vaxis.Style{}, "31", vaxis.Style{Foreground: vaxis.IndexColor(1)}},
+		{vaxis.Style{}, "91", vaxis.Style{Foreground: vaxis.IndexColor(9)}},
+		{vaxis.Style{}, "42", vaxis.Style{Background: vaxis.IndexColor(2)}},
+		{vaxis.Style{}, "102", vaxis.Style{Background: vaxis.IndexColor(10)}},
+		{vaxis.Style{Foreground: vaxis.IndexColor(1)}, "39", vaxis.Style{}},
+		{vaxis.Style{}, "38;5;200", vaxis.Style{Foreground: vaxis.IndexColor(200)}},
+		{vaxis.Style{}, "38:5:200", vaxis.Style{Foreground: vaxis.IndexColor(200)}},
+		{vaxis.Style{}, "38;2;1;2;3", vaxis.Style{Foreground: vaxis.RGBColor(1, 2, 3)}},
+		{vaxis.Style{}, "38:2:1:2:3", vaxis.Style{Foreground: vaxis.RGBColor(1, 2, 3)}},
+		{vaxis.Style{}, "38:2::1:2:3", vaxis.Style{Foreground: vaxis.RGBColor(1, 2, 3)}},
+		{vaxis.Style{}, "48;5;17;1", vaxis.Style{Background: vaxis.IndexColor(17), Attribute: vaxis.AttrBold}},
+		{vaxis.Style{}, "38;2;1;2;3;4", vaxis.Style{Foreground: vaxis.RGBColor(1, 2, 3), UnderlineStyle: vaxis.UnderlineSingle}},
+		{vaxis.Style{Hyperlink: "u"}, "0", vaxis.Style{Hyperlink: "u"}},
+	}
+	for _, tt := range tests {
+		if got := applySGR(tt.from, tt.params); got != tt.want {
+			t.Errorf("applySGR(%+v, %q) = %+v, want %+v", tt.from, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmenu", "history.json")
+	h, err := loadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ids := range [][]string{{"a", "b"}, {"a"}} {
+		if err := h.record("s", ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.record("t", []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	for range historyVisits + 2 {
+		if err := h.record("t", []string{"many"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err = loadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[historyKey]int{}
+	for k, item := range h.items {
+		counts[k] = item.Count
+		if want := min(item.Count, historyVisits); len(item.Visits) != want {
+			t.Errorf("%v has %d visits, want %d", k, len(item.Visits), want)
+		}
+	}
+	want := map[historyKey]int{{"s", "a"}: 2, {"s", "b"}: 1, {"t", "a"}: 1, {"t", "many"}: historyVisits + 2}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+	if scores := h.scores("s"); len(scores) != 2 || scores["a"] <= scores["b"] {
+		t.Errorf("scores = %v, want a above b", scores)
+	}
+
+	if ok, err := h.forget("s", "a"); !ok || err != nil {
+		t.Errorf("forget = %v, %v, want true", ok, err)
+	}
+	if ok, err := h.forget("s", "a"); ok || err != nil {
+		t.Errorf("forget again = %v, %v, want false", ok, err)
+	}
+	h, err = loadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scores := h.scores("s"); !reflect.DeepEqual(scores, map[string]float64{"b": 100}) {
+		t.Errorf("scores after forget = %v", scores)
+	}
+}
+
+func TestFrecency(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	ago := func(days float64) int64 {
+		return now.Add(-time.Duration(days * 24 * float64(time.Hour))).Unix()
+	}
+	tests := []struct {
+		item historyItem
+		want float64
+	}{
+		{historyItem{}, 0},
+		{historyItem{Count: 1, Visits: []int64{ago(0)}}, 100},
+		{historyItem{Count: 1, Visits: []int64{ago(3.9)}}, 100},
+		{historyItem{Count: 1, Visits: []int64{ago(4)}}, 70},
+		{historyItem{Count: 1, Visits: []int64{ago(20)}}, 50},
+		{historyItem{Count: 1, Visits: []int64{ago(60)}}, 30},
+		{historyItem{Count: 1, Visits: []int64{ago(365)}}, 10},
+		{historyItem{Count: 2, Visits: []int64{ago(365), ago(1)}}, 110},
+		// older picks that weren't kept count as the average of the kept ones
+		{historyItem{Count: 20, Visits: []int64{ago(365), ago(1)}}, 1100},
+	}
+	for _, tt := range tests {
+		if got := frecency(&tt.item, now); got != tt.want {
+			t.Errorf("frecency(%+v) = %v, want %v", tt.item, got, tt.want)
+		}
+	}
+}
+
+func TestParseItemsJSONL(t *testing.T) {
+	red := vaxis.Style{Foreground: vaxis.IndexColor(1)}
+	tests := []struct {
+		line string
+		want []listItem
+	}{
+		{`{"text": "a", "id": "x"}`, []listItem{{text: "a", id: "x", arg: "x"}}},
+		// scripts without ids get the whole line back, so they can keep their own data in it
+		{`{"text": "a", "data": {"n": 1}}`, []listItem{{text: "a", id: `{"text": "a", "data": {"n": 1}}`, arg: `{"text": "a", "data": {"n": 1}}`}}},
+		{`{"columns": ["a", "b"], "id": "x"}`, []listItem{{text: "a\tb", id: "x", arg: "x"}}},
+		{`{"text": "a", "columns": ["b"], "id": "x"}`, []listItem{{text: "a", id: "x", arg: "x"}}},
+		{`{"text": "\u001b[31ma", "id": "x"}`, []listItem{{text: "a", id: "x", arg: "x", sgr: []vaxis.Style{red}}}},
+		{`{"text": "a", "id": "x", "style": ["highlight", "dim"], "fg": 3, "bg": 255, "right": "r", "icon": "i"}`, []listItem{{
+			text: "a", id: "x", arg: "x",
+			style: lineStyle{highlight: true, dim: true, fg: vaxis.IndexColor(3), bg: vaxis.IndexColor(255), right: "r", icon: "i"},
+		}}},
+		{`{"text": "a", "id": "x", "preview": "p"}`, []listItem{{text: "a", id: "x", arg: "x", preview: &itemPreview{raw: "p"}}}},
+		{"  ", []listItem{}},
+	}
+	for _, tt := range tests {
+		if got := parseItems(formatJSONL, []string{tt.line}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseItems(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+
+	// bad lines are error rows rather than dropped
+	errTests := []struct {
+		line string
+		err  string
+	}{
+		{`{"text": "a", "style": ["bold"]}`, `unknown style "bold"`},
+		{`{"text": "a", "fg": 300}`, "300"},
+		{`{"text": "a", "bg": -1}`, "-1"},
+		{`not json`, "invalid character"},
+	}
+	for _, tt := range errTests {
+		got := parseItems(formatJSONL, []string{tt.line})
+		if len(got) != 1 || !got[0].style.error || got[0].id != got[0].text ||
+			!strings.HasPrefix(got[0].text, "✗ line 1: ") || !strings.Contains(got[0].text, tt.err) {
+			t.Errorf("parseItems(%q) = %+v, want an error row with %q", tt.line, got, tt.err)
+		}
+	}
+
+	// error rows are numbered by line, and don't stop the lines after them
+	items := parseItems(formatJSONL, []string{`{"text": "a"}`, `{`, `{"text": "c"}`})
+	var texts []string
+	for _, it := range items {
+		texts = append(texts, it.text)
+	}
+	if len(texts) != 3 || texts[0] != "a" || !strings.HasPrefix(texts[1], "✗ line 2: ") || texts[2] != "c" {
+		t.Errorf("texts = %q, want a, an error for line 2, then c", texts)
+	}
+}